@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/nmollerup/sensu-check-disk/internal/statefile"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// minForecastSamples is the number of samples needed before a trend is fitted
+const minForecastSamples = 3
+
+// Sample is a single disk.Usage observation stored in the forecast state file
+type Sample struct {
+	Time       int64  `json:"time"`
+	Used       uint64 `json:"used"`
+	Free       uint64 `json:"free"`
+	InodesUsed uint64 `json:"inodes_used"`
+	InodesFree uint64 `json:"inodes_free"`
+}
+
+// ForecastState maps mountpoints to their recent usage samples
+type ForecastState map[string][]Sample
+
+func loadForecastState(path string) ForecastState {
+	state := ForecastState{}
+	if !statefile.Load(path, &state) {
+		return ForecastState{}
+	}
+	return state
+}
+
+// defaultStateFile returns the state file used when --state-file is not set,
+// named after the options that select and name the mountpoints so check
+// definitions with different filters keep separate histories
+func defaultStateFile() (string, error) {
+	keys := []string{plugin.Profile, plugin.DedupeBy, plugin.HostRoot}
+	for _, values := range [][]string{
+		plugin.IgnorePaths, plugin.IncludePaths,
+		plugin.IgnoreTypes, plugin.IncludeTypes,
+		plugin.IgnoreDevices, plugin.IncludeDevices,
+		plugin.IgnoreLabels, plugin.IncludeLabels,
+	} {
+		keys = append(keys, strings.Join(values, "\x01"))
+	}
+	return statefile.DefaultPath("check-disk-usage", keys...)
+}
+
+// record appends a usage sample for the mountpoint and drops the samples of
+// every mountpoint that are older than the forecast window. Mountpoints that
+// were not sampled within the window are removed, so the history of mounts
+// another check definition does not select is kept as long as it is useful.
+func (s ForecastState) record(mountpoint string, usage *disk.UsageStat, now time.Time) []Sample {
+	cutoff := now.Add(-time.Duration(plugin.ForecastWindow * float64(time.Hour))).Unix()
+
+	for m, samples := range s {
+		var kept []Sample
+		for _, sample := range samples {
+			if sample.Time >= cutoff && sample.Time < now.Unix() {
+				kept = append(kept, sample)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s, m)
+		} else {
+			s[m] = kept
+		}
+	}
+
+	samples := append(s[mountpoint], Sample{
+		Time:       now.Unix(),
+		Used:       usage.Used,
+		Free:       usage.Free,
+		InodesUsed: usage.InodesUsed,
+		InodesFree: usage.InodesFree,
+	})
+
+	s[mountpoint] = samples
+	return samples
+}
+
+// timeToFull fits a least squares line through the used values of the
+// samples and returns how long it takes the growth to consume the free space
+// of the latest sample. ok is false when there is not enough data or usage
+// is not growing.
+func timeToFull(samples []Sample, used func(Sample) uint64, free func(Sample) uint64) (time.Duration, bool) {
+	if len(samples) < minForecastSamples {
+		return 0, false
+	}
+
+	// Offset times by the first sample to keep the sums small
+	t0 := samples[0].Time
+	var sumX, sumY, sumXY, sumXX float64
+	for _, sample := range samples {
+		x := float64(sample.Time - t0)
+		y := float64(used(sample))
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+
+	n := float64(len(samples))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, false
+	}
+
+	// Growth rate in units per second
+	slope := (n*sumXY - sumX*sumY) / denominator
+	if slope <= 0 || math.IsNaN(slope) {
+		return 0, false
+	}
+
+	seconds := float64(free(samples[len(samples)-1])) / slope
+	if seconds > float64(math.MaxInt64/int64(time.Second)) {
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// evaluateForecast projects when the disk space and inodes of a mountpoint
+// run out and compares that against the forecast horizons
+func evaluateForecast(mountpoint string, samples []Sample, now time.Time) (criticals []string, warnings []string) {
+	resources := []struct {
+		name string
+		used func(Sample) uint64
+		free func(Sample) uint64
+	}{
+		{"disk", func(s Sample) uint64 { return s.Used }, func(s Sample) uint64 { return s.Free }},
+		{"inodes", func(s Sample) uint64 { return s.InodesUsed }, func(s Sample) uint64 { return s.InodesFree }},
+	}
+
+	for _, resource := range resources {
+		remaining, ok := timeToFull(samples, resource.used, resource.free)
+		if !ok {
+			continue
+		}
+
+		hours := remaining.Hours()
+		msg := fmt.Sprintf("%s %s full in %s (around %s)", mountpoint, resource.name,
+			remaining.Round(time.Minute), now.Add(remaining).Format(time.RFC3339))
+
+		if plugin.ForecastCritical > 0 && hours < plugin.ForecastCritical {
+			criticals = append(criticals, msg)
+		} else if plugin.ForecastWarning > 0 && hours < plugin.ForecastWarning {
+			warnings = append(warnings, msg)
+		}
+	}
+
+	return criticals, warnings
+}
@@ -1,8 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/nmollerup/sensu-check-disk/internal/btrfs"
+	"github.com/nmollerup/sensu-check-disk/internal/bytesize"
+	"github.com/nmollerup/sensu-check-disk/internal/capacity"
+	"github.com/nmollerup/sensu-check-disk/internal/filter"
+	"github.com/nmollerup/sensu-check-disk/internal/hostroot"
+	"github.com/nmollerup/sensu-check-disk/internal/kubelet"
+	"github.com/nmollerup/sensu-check-disk/internal/statefile"
+	"github.com/nmollerup/sensu-check-disk/internal/statfs"
 	corev2 "github.com/sensu/core/v2"
 	"github.com/sensu/sensu-plugin-sdk/sensu"
 	"github.com/shirou/gopsutil/v3/disk"
@@ -11,12 +22,44 @@ import (
 // Config represents the check plugin config
 type Config struct {
 	sensu.PluginConfig
-	Warning      float64
-	Critical     float64
-	IgnorePaths  []string
-	IncludePaths []string
-	IgnoreTypes  []string
-	IncludeTypes []string
+	Warning        float64
+	Critical       float64
+	InodeWarning   float64
+	InodeCritical  float64
+	Profile        string
+	IgnorePaths    []string
+	IncludePaths   []string
+	IgnoreTypes    []string
+	IncludeTypes   []string
+	IgnoreDevices  []string
+	IncludeDevices []string
+	RulesFile      string
+	WarningFree    string
+	CriticalFree   string
+	Magic          float64
+	Normal         float64
+	Minimum        float64
+
+	ForecastWarning  float64
+	ForecastCritical float64
+	ForecastWindow   float64
+	StateFile        string
+	MetricsFormat    string
+
+	Concurrency     int
+	MountTimeout    float64
+	TimeoutSeverity string
+	ErrorSeverity   string
+	RequireMounts   bool
+	DedupeBy        string
+	Btrfs           bool
+	HostRoot        string
+	Kubernetes      bool
+	IgnoreLabels    []string
+	IncludeLabels   []string
+
+	warningFree  uint64
+	criticalFree uint64
 }
 
 var (
@@ -43,43 +86,245 @@ var (
 			Usage:     "Critical threshold percentage for disk usage",
 			Value:     &plugin.Critical,
 		},
+		&sensu.PluginConfigOption[float64]{
+			Path:      "InodeWarning",
+			Argument:  "inode-warning",
+			Shorthand: "W",
+			Usage:     "Warning threshold percentage for inode usage (0 to disable)",
+			Value:     &plugin.InodeWarning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:      "InodeCritical",
+			Argument:  "inode-critical",
+			Shorthand: "K",
+			Usage:     "Critical threshold percentage for inode usage (0 to disable)",
+			Value:     &plugin.InodeCritical,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "Profile",
+			Argument: "profile",
+			Default:  filter.ProfileAll,
+			Allow:    filter.Profiles,
+			Usage:    "Named set of filesystem type and mount option rules selecting the partitions to check, refined by the other filters: all, local, physical or network",
+			Value:    &plugin.Profile,
+		},
 		&sensu.SlicePluginConfigOption[string]{
 			Path:      "IgnorePaths",
 			Argument:  "ignore-paths",
 			Shorthand: "i",
-			Usage:     "Comma-separated list of mount paths to ignore",
+			Usage:     "Comma-separated list of mount paths to ignore (globs and ~regex supported)",
 			Value:     &plugin.IgnorePaths,
 		},
 		&sensu.SlicePluginConfigOption[string]{
 			Path:      "IncludePaths",
 			Argument:  "include-paths",
 			Shorthand: "I",
-			Usage:     "Comma-separated list of mount paths to include (if set, only these are checked)",
+			Usage:     "Comma-separated list of mount paths to include (if set, only these are checked; globs and ~regex supported)",
 			Value:     &plugin.IncludePaths,
 		},
 		&sensu.SlicePluginConfigOption[string]{
 			Path:      "IgnoreTypes",
 			Argument:  "ignore-types",
 			Shorthand: "x",
-			Usage:     "Comma-separated list of filesystem types to ignore",
+			Usage:     "Comma-separated list of filesystem types to ignore (globs and ~regex supported)",
 			Value:     &plugin.IgnoreTypes,
 		},
 		&sensu.SlicePluginConfigOption[string]{
 			Path:      "IncludeTypes",
 			Argument:  "include-types",
 			Shorthand: "t",
-			Usage:     "Comma-separated list of filesystem types to include (if set, only these are checked)",
+			Usage:     "Comma-separated list of filesystem types to include (if set, only these are checked; globs and ~regex supported)",
 			Value:     &plugin.IncludeTypes,
 		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:      "IgnoreDevices",
+			Argument:  "ignore-devices",
+			Shorthand: "d",
+			Usage:     "Comma-separated list of devices to ignore (globs and ~regex supported)",
+			Value:     &plugin.IgnoreDevices,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:      "IncludeDevices",
+			Argument:  "include-devices",
+			Shorthand: "D",
+			Usage:     "Comma-separated list of devices to include (if set, only these are checked; globs and ~regex supported)",
+			Value:     &plugin.IncludeDevices,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:      "RulesFile",
+			Argument:  "rules-file",
+			Shorthand: "r",
+			Usage:     "Path to JSON or YAML (.yaml, .yml) file with per-mountpoint or per-fstype threshold rules",
+			Value:     &plugin.RulesFile,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "WarningFree",
+			Argument: "warning-free",
+			Usage:    "Warning when free space is below this size (e.g. 10GiB, 500MB)",
+			Value:    &plugin.WarningFree,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "CriticalFree",
+			Argument: "critical-free",
+			Usage:    "Critical when free space is below this size (e.g. 5GiB, 200MB)",
+			Value:    &plugin.CriticalFree,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:      "Magic",
+			Argument:  "magic",
+			Shorthand: "m",
+			Default:   1.0,
+			Usage:     "Magic factor to raise percentage thresholds for filesystems larger than --normal, and lower them for smaller ones of at least --minimum GiB (1.0 disables adjustment)",
+			Value:     &plugin.Magic,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:      "Normal",
+			Argument:  "normal",
+			Shorthand: "n",
+			Default:   20,
+			Usage:     "Filesystem size in GiB for which percentage thresholds are not adjusted by --magic",
+			Value:     &plugin.Normal,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:      "Minimum",
+			Argument:  "minimum",
+			Shorthand: "l",
+			Default:   100,
+			Usage:     "Minimum filesystem size in GiB for --magic adjustment to apply",
+			Value:     &plugin.Minimum,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "ForecastWarning",
+			Argument: "forecast-warning",
+			Usage:    "Warning when a filesystem is projected to be full within this many hours (0 to disable)",
+			Value:    &plugin.ForecastWarning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "ForecastCritical",
+			Argument: "forecast-critical",
+			Usage:    "Critical when a filesystem is projected to be full within this many hours (0 to disable)",
+			Value:    &plugin.ForecastCritical,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "ForecastWindow",
+			Argument: "forecast-window",
+			Default:  24,
+			Usage:    "Hours of usage history used to fit the growth trend",
+			Value:    &plugin.ForecastWindow,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "StateFile",
+			Argument: "state-file",
+			Usage:    "Path to the file storing usage history for forecasting (default: a file in the user cache directory named after the filter options)",
+			Value:    &plugin.StateFile,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "MetricsFormat",
+			Argument: "metrics-format",
+			Default:  metricsFormatNone,
+			Allow:    []string{metricsFormatNone, metricsFormatNagios, metricsFormatPrometheus},
+			Usage:    "Append per-mount metrics to the output: none, nagios_perfdata or prometheus_text",
+			Value:    &plugin.MetricsFormat,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "Concurrency",
+			Argument: "concurrency",
+			Default:  4,
+			Usage:    "Number of mountpoints to query in parallel",
+			Value:    &plugin.Concurrency,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "MountTimeout",
+			Argument: "mount-timeout",
+			Default:  10,
+			Usage:    "Seconds to wait for the usage of a single mountpoint (0 to wait forever)",
+			Value:    &plugin.MountTimeout,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "TimeoutSeverity",
+			Argument: "timeout-severity",
+			Default:  severityCritical,
+			Allow:    severities,
+			Usage:    "Severity of mountpoints that do not answer within --mount-timeout: ignore, warning, critical or unknown",
+			Value:    &plugin.TimeoutSeverity,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "ErrorSeverity",
+			Argument: "error-severity",
+			Default:  severityWarning,
+			Allow:    severities,
+			Usage:    "Severity of mountpoints whose usage cannot be read (e.g. permission denied, stale handle): ignore, warning, critical or unknown",
+			Value:    &plugin.ErrorSeverity,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "RequireMounts",
+			Argument: "require-mounts",
+			Usage:    "Report critical when a mount path given with --include-paths is not mounted (patterns are not required)",
+			Value:    &plugin.RequireMounts,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "DedupeBy",
+			Argument: "dedupe-by",
+			Default:  statfs.DedupeNone,
+			Allow:    statfs.DedupeModes,
+			Usage:    "Evaluate each filesystem once by grouping mountpoints by device or filesystem: none, device or filesystem",
+			Value:    &plugin.DedupeBy,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "Btrfs",
+			Argument: "btrfs",
+			Usage:    "Also evaluate btrfs data and metadata chunk allocation from /sys/fs/btrfs against the thresholds",
+			Value:    &plugin.Btrfs,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "HostRoot",
+			Argument: "host-root",
+			Env:      "HOST_ROOT",
+			Usage:    "Directory the host's root filesystem is mounted at when running in a container (e.g. /host)",
+			Value:    &plugin.HostRoot,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "Kubernetes",
+			Argument: "kubernetes",
+			Default:  false,
+			Usage:    "Name kubelet volume mounts by PV, pod UID and volume instead of their mountpoint",
+			Value:    &plugin.Kubernetes,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "IgnoreLabels",
+			Argument: "ignore-labels",
+			Usage:    "Comma-separated list of label=pattern entries; kubelet volumes with a matching pod_uid, volume, plugin or pv label are ignored",
+			Value:    &plugin.IgnoreLabels,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "IncludeLabels",
+			Argument: "include-labels",
+			Usage:    "Comma-separated list of label=pattern entries; if set, only kubelet volumes with a matching label are checked (e.g. pv=* for PV-backed volumes)",
+			Value:    &plugin.IncludeLabels,
+		},
 	}
+
+	rules []capacity.Rule
+)
+
+// Severities accepted by the --*-severity options
+const (
+	severityIgnore   = "ignore"
+	severityWarning  = "warning"
+	severityCritical = "critical"
+	severityUnknown  = "unknown"
 )
 
+var severities = []string{severityIgnore, severityWarning, severityCritical, severityUnknown}
+
 func main() {
 	check := sensu.NewCheck(&plugin.PluginConfig, options, checkArgs, executeCheck, false)
 	check.Execute()
 }
 
 func checkArgs(event *corev2.Event) (int, error) {
+	hostroot.Set(plugin.HostRoot)
+
 	if plugin.Critical <= 0 {
 		return sensu.CheckStateWarning, fmt.Errorf("--critical is required and must be greater than 0")
 	}
@@ -89,79 +334,299 @@ func checkArgs(event *corev2.Event) (int, error) {
 	if plugin.Warning >= plugin.Critical {
 		return sensu.CheckStateWarning, fmt.Errorf("--warning must be less than --critical")
 	}
+	if plugin.InodeWarning < 0 || plugin.InodeCritical < 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--inode-warning and --inode-critical must not be negative")
+	}
+	if plugin.InodeWarning > 0 && plugin.InodeCritical > 0 && plugin.InodeWarning >= plugin.InodeCritical {
+		return sensu.CheckStateWarning, fmt.Errorf("--inode-warning must be less than --inode-critical")
+	}
+	if plugin.Magic <= 0 || plugin.Magic > 1 {
+		return sensu.CheckStateWarning, fmt.Errorf("--magic must be greater than 0 and at most 1")
+	}
+	if plugin.Normal <= 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--normal must be greater than 0")
+	}
+	if plugin.WarningFree != "" {
+		size, err := bytesize.Parse(plugin.WarningFree)
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("invalid --warning-free: %v", err)
+		}
+		plugin.warningFree = size
+	}
+	if plugin.CriticalFree != "" {
+		size, err := bytesize.Parse(plugin.CriticalFree)
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("invalid --critical-free: %v", err)
+		}
+		plugin.criticalFree = size
+	}
+	if plugin.warningFree > 0 && plugin.criticalFree > 0 && plugin.warningFree <= plugin.criticalFree {
+		return sensu.CheckStateWarning, fmt.Errorf("--warning-free must be greater than --critical-free")
+	}
+	if plugin.ForecastWarning < 0 || plugin.ForecastCritical < 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--forecast-warning and --forecast-critical must not be negative")
+	}
+	if plugin.ForecastWarning > 0 && plugin.ForecastCritical > 0 && plugin.ForecastWarning <= plugin.ForecastCritical {
+		return sensu.CheckStateWarning, fmt.Errorf("--forecast-warning must be greater than --forecast-critical")
+	}
+	if forecasting() && plugin.ForecastWindow <= 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--forecast-window must be greater than 0")
+	}
+	if forecasting() && plugin.StateFile == "" {
+		path, err := defaultStateFile()
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("no default forecast state file, set --state-file: %v", err)
+		}
+		plugin.StateFile = path
+	}
+	if plugin.RulesFile != "" {
+		var err error
+		rules, err = capacity.LoadRules(plugin.RulesFile, defaultThresholds())
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("failed to load rules file: %v", err)
+		}
+	}
+	if err := partitionFilter().Validate(); err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	if err := labelFilter().Validate(); err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	if plugin.RequireMounts && !hasLiteral(plugin.IncludePaths) {
+		return sensu.CheckStateWarning, fmt.Errorf("--require-mounts needs at least one --include-paths entry that is not a pattern")
+	}
+	if plugin.Concurrency < 1 {
+		return sensu.CheckStateWarning, fmt.Errorf("--concurrency must be at least 1")
+	}
+	if plugin.MountTimeout < 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--mount-timeout must not be negative")
+	}
 	return sensu.CheckStateOK, nil
 }
 
 func executeCheck(event *corev2.Event) (int, error) {
-	partitions, err := disk.Partitions(false)
+	partitions, err := hostroot.Partitions(partitionFilter().AllPartitions())
 	if err != nil {
 		return sensu.CheckStateCritical, fmt.Errorf("failed to get disk partitions: %v", err)
 	}
 
-	var warnings []string
-	var criticals []string
+	var f findings
+	var metrics []mountMetrics
 
-	for _, partition := range partitions {
-		// Skip if filesystem type should be ignored
-		if shouldIgnoreType(partition.Fstype) {
-			continue
-		}
+	now := time.Now()
+	var state ForecastState
+	if forecasting() {
+		state = loadForecastState(plugin.StateFile)
+	}
 
-		// Skip if not in include types (when include types is specified)
-		if len(plugin.IncludeTypes) > 0 && !contains(plugin.IncludeTypes, partition.Fstype) {
+	var selected []disk.PartitionStat
+	for _, partition := range partitions {
+		if partitionFilter().Skip(partition) || labelFilter().Skip(partition.Mountpoint) {
 			continue
 		}
+		selected = append(selected, partition)
+	}
 
-		// Skip if mount point should be ignored
-		if contains(plugin.IgnorePaths, partition.Mountpoint) {
-			continue
+	if plugin.RequireMounts {
+		all, err := hostroot.Partitions(true)
+		if err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("failed to get mounted partitions: %v", err)
 		}
-
-		// Skip if not in include paths (when include paths is specified)
-		if len(plugin.IncludePaths) > 0 && !contains(plugin.IncludePaths, partition.Mountpoint) {
-			continue
+		for _, path := range missingMounts(all, plugin.IncludePaths) {
+			f.criticals = append(f.criticals, fmt.Sprintf("%s is not mounted", path))
 		}
+	}
 
-		usage, err := disk.Usage(partition.Mountpoint)
-		if err != nil {
-			// Skip partitions we can't read (e.g., permission issues)
-			continue
+	timeout := time.Duration(plugin.MountTimeout * float64(time.Second))
+	results := statfs.Dedupe(statfs.Collect(selected, plugin.Concurrency, timeout), plugin.DedupeBy)
+	for _, result := range results {
+		if m, ok := evaluateResult(&f, result, timeout, state, now); ok {
+			metrics = append(metrics, m)
 		}
+	}
 
-		usedPercent := usage.UsedPercent
-
-		if usedPercent >= plugin.Critical {
-			criticals = append(criticals, fmt.Sprintf("%s at %.2f%% usage", partition.Mountpoint, usedPercent))
-		} else if usedPercent >= plugin.Warning {
-			warnings = append(warnings, fmt.Sprintf("%s at %.2f%% usage", partition.Mountpoint, usedPercent))
+	if forecasting() {
+		if err := statefile.Save(plugin.StateFile, state); err != nil {
+			f.warnings = append(f.warnings, fmt.Sprintf("failed to save forecast state: %v", err))
 		}
 	}
+	criticals, warnings, unknowns := f.criticals, f.warnings, f.unknowns
 
 	// Return critical if any critical thresholds are exceeded
 	if len(criticals) > 0 {
-		fmt.Printf("CRITICAL - Disk usage exceeded critical threshold on: %v\n", criticals)
+		fmt.Println(withMetrics(fmt.Sprintf("CRITICAL - Disk usage exceeded critical threshold on: %v", criticals), metrics))
 		return sensu.CheckStateCritical, nil
 	}
 
+	// Return unknown if any mountpoint could not be checked
+	if len(unknowns) > 0 {
+		fmt.Println(withMetrics(fmt.Sprintf("UNKNOWN - Unable to check disk usage on: %v", unknowns), metrics))
+		return sensu.CheckStateUnknown, nil
+	}
+
 	// Return warning if any warning thresholds are exceeded
 	if len(warnings) > 0 {
-		fmt.Printf("WARNING - Disk usage exceeded warning threshold on: %v\n", warnings)
+		fmt.Println(withMetrics(fmt.Sprintf("WARNING - Disk usage exceeded warning threshold on: %v", warnings), metrics))
 		return sensu.CheckStateWarning, nil
 	}
 
-	fmt.Println("OK - All disk usage within thresholds")
+	fmt.Println(withMetrics("OK - All disk usage within thresholds", metrics))
 	return sensu.CheckStateOK, nil
 }
 
-func shouldIgnoreType(fstype string) bool {
-	return contains(plugin.IgnoreTypes, fstype)
+// findings collects the messages of a run by severity
+type findings struct {
+	criticals []string
+	warnings  []string
+	unknowns  []string
 }
 
-func contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
+// report adds msg with one of the --*-severity values
+func (f *findings) report(severity, msg string) {
+	switch severity {
+	case severityCritical:
+		f.criticals = append(f.criticals, msg)
+	case severityWarning:
+		f.warnings = append(f.warnings, msg)
+	case severityUnknown:
+		f.unknowns = append(f.unknowns, msg)
+	}
+}
+
+func (f *findings) add(criticals, warnings []string) {
+	f.criticals = append(f.criticals, criticals...)
+	f.warnings = append(f.warnings, warnings...)
+}
+
+// evaluateResult checks the usage lookup of one mountpoint, and its btrfs
+// allocation and forecast when enabled, adding the problems to f. Lookups
+// that timed out or failed are reported with --timeout-severity and
+// --error-severity, and yield no metrics.
+func evaluateResult(f *findings, result statfs.Result, timeout time.Duration, state ForecastState, now time.Time) (mountMetrics, bool) {
+	partition, usage := result.Partition, result.Usage
+	if errors.Is(result.Err, statfs.ErrTimeout) {
+		f.report(plugin.TimeoutSeverity, fmt.Sprintf("%s did not respond within %s", partition.Mountpoint, timeout))
+		return mountMetrics{}, false
+	}
+	if result.Err != nil {
+		f.report(plugin.ErrorSeverity, fmt.Sprintf("%s could not be read: %v", partition.Mountpoint, result.Err))
+		return mountMetrics{}, false
+	}
+
+	name := partition.Mountpoint
+	var volume *kubelet.Volume
+	if plugin.Kubernetes {
+		if v, ok := kubelet.Parse(partition.Mountpoint); ok {
+			name = v.String()
+			volume = &v
+		}
+	}
+	if len(result.Aliases) > 0 {
+		name = fmt.Sprintf("%s (also mounted at %s)", name, strings.Join(result.Aliases, ", "))
+	}
+
+	thresholds := thresholdsFor(partition.Mountpoint, partition.Fstype)
+	f.add(capacity.Evaluate(name, usage, thresholds, scaling()))
+
+	if plugin.Btrfs && partition.Fstype == "btrfs" {
+		allocation, err := btrfs.ForDevice(hostroot.Sys(), partition.Device)
+		if err != nil {
+			f.report(plugin.ErrorSeverity, fmt.Sprintf("%s btrfs allocation could not be read: %v", name, err))
+		} else {
+			f.add(evaluateBtrfs(name, allocation, thresholds))
+		}
+	}
+
+	if forecasting() {
+		samples := state.record(partition.Mountpoint, usage, now)
+		f.add(evaluateForecast(name, samples, now))
+	}
+
+	return mountMetrics{
+		Mountpoint: partition.Mountpoint,
+		Fstype:     partition.Fstype,
+		Volume:     volume,
+		Usage:      usage,
+		Thresholds: thresholds,
+	}, true
+}
+
+// evaluateBtrfs compares btrfs data and metadata usage, counting the
+// unallocated space each can still grow into, against the given thresholds
+func evaluateBtrfs(mountpoint string, a *btrfs.Allocation, t capacity.Thresholds) (criticals []string, warnings []string) {
+	spaces := []struct {
+		name  string
+		space btrfs.Space
+	}{
+		{"data", a.Data},
+		{"metadata", a.Metadata},
+	}
+
+	for _, s := range spaces {
+		usedPercent := a.UsedPercent(s.space)
+		msg := fmt.Sprintf("%s at %.2f%% btrfs %s usage (%s unallocated)", mountpoint, usedPercent, s.name, bytesize.Format(a.Unallocated()))
+		if usedPercent >= t.Critical {
+			criticals = append(criticals, msg)
+		} else if usedPercent >= t.Warning {
+			warnings = append(warnings, msg)
+		}
+	}
+
+	return criticals, warnings
+}
+
+// hasLiteral reports whether any of the paths is a plain path rather than a
+// pattern
+func hasLiteral(paths []string) bool {
+	for _, path := range paths {
+		if !filter.IsPattern(path) {
 			return true
 		}
 	}
 	return false
 }
+
+// missingMounts returns the literal paths among includePaths that are not a
+// mountpoint of any of the partitions, which should include the pseudo
+// filesystems left out of the usage checks
+func missingMounts(partitions []disk.PartitionStat, includePaths []string) []string {
+	mounted := make(map[string]bool)
+	for _, partition := range partitions {
+		mounted[partition.Mountpoint] = true
+	}
+
+	var missing []string
+	for _, path := range includePaths {
+		if filter.IsPattern(path) {
+			continue
+		}
+		if !mounted[path] {
+			missing = append(missing, path)
+		}
+	}
+	return missing
+}
+
+// forecasting reports whether time-to-full forecasting is enabled
+func forecasting() bool {
+	return plugin.ForecastWarning > 0 || plugin.ForecastCritical > 0
+}
+
+func labelFilter() kubelet.Filter {
+	return kubelet.Filter{
+		IgnoreLabels:  plugin.IgnoreLabels,
+		IncludeLabels: plugin.IncludeLabels,
+	}
+}
+
+func partitionFilter() filter.Filter {
+	return filter.Filter{
+		Profile:        plugin.Profile,
+		IgnorePaths:    plugin.IgnorePaths,
+		IncludePaths:   plugin.IncludePaths,
+		IgnoreTypes:    plugin.IgnoreTypes,
+		IncludeTypes:   plugin.IncludeTypes,
+		IgnoreDevices:  plugin.IgnoreDevices,
+		IncludeDevices: plugin.IncludeDevices,
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nmollerup/sensu-check-disk/internal/btrfs"
+	"github.com/nmollerup/sensu-check-disk/internal/capacity"
+	"github.com/nmollerup/sensu-check-disk/internal/kubelet"
+	"github.com/nmollerup/sensu-check-disk/internal/statfs"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+func TestMain(m *testing.M) {
+	// Option defaults are normally applied by the plugin SDK
+	plugin.Magic = 1.0
+	plugin.Normal = 20
+	plugin.Minimum = 100
+	os.Exit(m.Run())
+}
+
+func TestThresholdsFor_Rules(t *testing.T) {
+	plugin.Warning = 80
+	plugin.Critical = 90
+	plugin.InodeWarning = 0
+	plugin.InodeCritical = 0
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	data := `{"rules": [
+		{"mountpoint": "/boot", "warning": 50, "critical": 60},
+		{"mountpoint": "/data/*", "critical": 98},
+		{"pv": "pvc-*", "warning": 70, "critical": 75},
+		{"fstype": "xfs", "warning": 95, "critical": 97}
+	]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	rules, err = capacity.LoadRules(path, defaultThresholds())
+	if err != nil {
+		t.Fatalf("unexpected error loading rules: %v", err)
+	}
+	defer func() { rules = nil }()
+
+	tests := []struct {
+		mountpoint string
+		fstype     string
+		want       capacity.Thresholds
+	}{
+		{"/boot", "ext4", capacity.Thresholds{Warning: 50, Critical: 60}},
+		{"/data/archive", "xfs", capacity.Thresholds{Warning: 80, Critical: 98}},
+		{"/srv", "xfs", capacity.Thresholds{Warning: 95, Critical: 97}},
+		{"/", "ext4", capacity.Thresholds{Warning: 80, Critical: 90}},
+		{"/var/lib/kubelet/pods/0f4c8a5e-6b1d-4c3e-9a2f-1d2e3f4a5b6c/volumes/kubernetes.io~csi/pvc-1234/mount", "ext4", capacity.Thresholds{Warning: 70, Critical: 75}},
+		{"/var/lib/kubelet/pods/0f4c8a5e-6b1d-4c3e-9a2f-1d2e3f4a5b6c/volumes/kubernetes.io~empty-dir/cache", "ext4", capacity.Thresholds{Warning: 80, Critical: 90}},
+	}
+
+	for _, tt := range tests {
+		if got := thresholdsFor(tt.mountpoint, tt.fstype); got != tt.want {
+			t.Errorf("thresholdsFor(%q, %q) = %+v, want %+v", tt.mountpoint, tt.fstype, got, tt.want)
+		}
+	}
+}
+
+func TestTimeToFull(t *testing.T) {
+	// 1 GiB per hour of growth with 10 GiB left
+	samples := []Sample{
+		{Time: 0, Used: 10 << 30, Free: 12 << 30},
+		{Time: 3600, Used: 11 << 30, Free: 11 << 30},
+		{Time: 7200, Used: 12 << 30, Free: 10 << 30},
+	}
+
+	remaining, ok := timeToFull(samples, func(s Sample) uint64 { return s.Used }, func(s Sample) uint64 { return s.Free })
+	if !ok {
+		t.Fatal("expected a forecast")
+	}
+	if remaining != 10*time.Hour {
+		t.Errorf("expected 10h to full, got %s", remaining)
+	}
+}
+
+func TestTimeToFull_NotGrowing(t *testing.T) {
+	samples := []Sample{
+		{Time: 0, Used: 12 << 30, Free: 10 << 30},
+		{Time: 3600, Used: 11 << 30, Free: 11 << 30},
+		{Time: 7200, Used: 10 << 30, Free: 12 << 30},
+	}
+
+	if _, ok := timeToFull(samples, func(s Sample) uint64 { return s.Used }, func(s Sample) uint64 { return s.Free }); ok {
+		t.Error("expected no forecast for shrinking usage")
+	}
+}
+
+func TestEvaluateForecast(t *testing.T) {
+	plugin.ForecastWarning = 48
+	plugin.ForecastCritical = 12
+	defer func() {
+		plugin.ForecastWarning = 0
+		plugin.ForecastCritical = 0
+	}()
+
+	// Disk grows 1 GiB per hour with 20 GiB left, inodes grow 1000 per hour with 5000 left
+	samples := []Sample{
+		{Time: 0, Used: 10 << 30, Free: 22 << 30, InodesUsed: 1000, InodesFree: 7000},
+		{Time: 3600, Used: 11 << 30, Free: 21 << 30, InodesUsed: 2000, InodesFree: 6000},
+		{Time: 7200, Used: 12 << 30, Free: 20 << 30, InodesUsed: 3000, InodesFree: 5000},
+	}
+
+	now := time.Unix(7200, 0).UTC()
+	criticals, warnings := evaluateForecast("/data", samples, now)
+	if len(criticals) != 1 || criticals[0] != "/data inodes full in 5h0m0s (around 1970-01-01T07:00:00Z)" {
+		t.Errorf("expected inode forecast critical, got %v", criticals)
+	}
+	if len(warnings) != 1 || warnings[0] != "/data disk full in 20h0m0s (around 1970-01-01T22:00:00Z)" {
+		t.Errorf("expected disk forecast warning, got %v", warnings)
+	}
+}
+
+func TestForecastStateRecord(t *testing.T) {
+	plugin.ForecastWindow = 1
+	state := ForecastState{
+		"/data": {
+			{Time: 0, Used: 1},
+			{Time: 3000, Used: 2},
+		},
+	}
+
+	samples := state.record("/data", &disk.UsageStat{Used: 3}, time.Unix(4000, 0))
+	if len(samples) != 2 || samples[0].Time != 3000 || samples[1].Time != 4000 {
+		t.Errorf("expected samples outside the window to be dropped, got %+v", samples)
+	}
+}
+
+func TestForecastStateRecord_OtherMounts(t *testing.T) {
+	plugin.ForecastWindow = 1
+	state := ForecastState{
+		"/other":   {{Time: 3500, Used: 1}},
+		"/gone":    {{Time: 100, Used: 1}},
+		"/partial": {{Time: 100, Used: 1}, {Time: 3600, Used: 2}},
+	}
+
+	state.record("/data", &disk.UsageStat{Used: 3}, time.Unix(4000, 0))
+	if len(state["/other"]) != 1 {
+		t.Errorf("expected the history of a mount not sampled this run to be kept, got %+v", state)
+	}
+	if _, ok := state["/gone"]; ok {
+		t.Errorf("expected a mount without samples in the window to be removed, got %+v", state)
+	}
+	if len(state["/partial"]) != 1 || state["/partial"][0].Time != 3600 {
+		t.Errorf("expected old samples of other mounts to expire, got %+v", state["/partial"])
+	}
+}
+
+func TestDefaultStateFile(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	defer func() { plugin.IncludePaths = nil }()
+
+	plugin.IncludePaths = []string{"/data"}
+	data, err := defaultStateFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugin.IncludePaths = []string{"/var"}
+	other, err := defaultStateFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data == other {
+		t.Errorf("expected different filters to use different state files, got %s", data)
+	}
+	if filepath.Dir(data) != filepath.Join(cache, "sensu-check-disk") {
+		t.Errorf("expected the state file in the user cache directory, got %s", data)
+	}
+}
+
+func TestFormatNagiosPerfdata(t *testing.T) {
+	metrics := []mountMetrics{
+		{
+			Mountpoint: "/mnt/my disk",
+			Fstype:     "ext4",
+			Usage: &disk.UsageStat{
+				Total:       1000,
+				Used:        500,
+				Free:        500,
+				UsedPercent: 50,
+				InodesTotal: 100,
+				InodesUsed:  25,
+			},
+			Thresholds: capacity.Thresholds{Warning: 80, Critical: 90, InodeCritical: 95},
+		},
+	}
+
+	want := "/mnt/my_disk_used=500B;800;900;0;1000 /mnt/my_disk_used_percent=50.00%;80.00;90.00;0;100 /mnt/my_disk_inodes_used_percent=25.00%;;95.00;0;100"
+	if got := formatNagiosPerfdata(metrics); got != want {
+		t.Errorf("formatNagiosPerfdata() = %q, want %q", got, want)
+	}
+}
+
+func TestFormatNagiosPerfdata_CollidingLabels(t *testing.T) {
+	usage := &disk.UsageStat{Total: 1000, Used: 500, Free: 500, UsedPercent: 50}
+	metrics := []mountMetrics{
+		{Mountpoint: "/a b", Fstype: "ext4", Usage: usage, Thresholds: capacity.Thresholds{Warning: 80, Critical: 90}},
+		{Mountpoint: "/a_b", Fstype: "ext4", Usage: usage, Thresholds: capacity.Thresholds{Warning: 80, Critical: 90}},
+	}
+
+	// Only the mounts of a PV are written once
+	if got := strings.Count(formatNagiosPerfdata(metrics), "/a_b_used="); got != 2 {
+		t.Errorf("expected both mountpoints to be written, got %d", got)
+	}
+}
+
+func TestFormatNagiosPerfdata_KubeletVolume(t *testing.T) {
+	metrics := []mountMetrics{
+		{
+			Mountpoint: "/var/lib/kubelet/pods/0f4c8a5e-6b1d-4c3e-9a2f-1d2e3f4a5b6c/volumes/kubernetes.io~csi/pvc-1234/mount",
+			Fstype:     "ext4",
+			Volume:     &kubelet.Volume{PodUID: "0f4c8a5e-6b1d-4c3e-9a2f-1d2e3f4a5b6c", Name: "pvc-1234", Plugin: "csi", PV: "pvc-1234"},
+			Usage:      &disk.UsageStat{Total: 1000, Used: 500, Free: 500, UsedPercent: 50},
+			Thresholds: capacity.Thresholds{Warning: 80, Critical: 90},
+		},
+		// A second pod mounting the same ReadWriteMany PV
+		{
+			Mountpoint: "/var/lib/kubelet/pods/7a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d/volumes/kubernetes.io~csi/pvc-1234/mount",
+			Fstype:     "ext4",
+			Volume:     &kubelet.Volume{PodUID: "7a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d", Name: "pvc-1234", Plugin: "csi", PV: "pvc-1234"},
+			Usage:      &disk.UsageStat{Total: 1000, Used: 500, Free: 500, UsedPercent: 50},
+			Thresholds: capacity.Thresholds{Warning: 80, Critical: 90},
+		},
+	}
+
+	// The PV is written once
+	want := "kubernetes.pv.pvc-1234_used=500B;800;900;0;1000 kubernetes.pv.pvc-1234_used_percent=50.00%;80.00;90.00;0;100"
+	if got := formatNagiosPerfdata(metrics); got != want {
+		t.Errorf("formatNagiosPerfdata() = %q, want %q", got, want)
+	}
+
+	wantLabels := `mountpoint="` + metrics[0].Mountpoint + `",fstype="ext4",pod_uid="0f4c8a5e-6b1d-4c3e-9a2f-1d2e3f4a5b6c",volume="pvc-1234",plugin="csi",pv="pvc-1234"`
+	if got := prometheusLabels(metrics[0]); got != wantLabels {
+		t.Errorf("prometheusLabels() = %s, want %s", got, wantLabels)
+	}
+}
+
+func TestWithMetrics_Prometheus(t *testing.T) {
+	plugin.MetricsFormat = metricsFormatPrometheus
+	defer func() { plugin.MetricsFormat = metricsFormatNone }()
+
+	metrics := []mountMetrics{
+		{
+			Mountpoint: "/",
+			Fstype:     "xfs",
+			Usage:      &disk.UsageStat{Total: 1000, Used: 500, Free: 500, UsedPercent: 50},
+			Thresholds: capacity.Thresholds{Warning: 80, Critical: 90},
+		},
+	}
+
+	got := withMetrics("OK - All disk usage within thresholds", metrics)
+	for _, line := range []string{
+		"# OK - All disk usage within thresholds",
+		`disk_used_bytes{mountpoint="/",fstype="xfs"} 500`,
+		`disk_used_percent{mountpoint="/",fstype="xfs"} 50`,
+		`disk_critical_percent{mountpoint="/",fstype="xfs"} 90`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("expected output to contain %q, got:\n%s", line, got)
+		}
+	}
+	if strings.Contains(got, "disk_inodes_used_percent{") {
+		t.Errorf("expected no inode samples for filesystem without inodes, got:\n%s", got)
+	}
+}
+
+func TestEvaluateBtrfs_MetadataFull(t *testing.T) {
+	thresholds := capacity.Thresholds{Warning: 80, Critical: 90}
+	allocation := &btrfs.Allocation{
+		Data:        btrfs.Space{TotalBytes: 50 << 30, BytesUsed: 30 << 30, DiskTotal: 50 << 30},
+		Metadata:    btrfs.Space{TotalBytes: 2 << 30, BytesUsed: 2 << 30, DiskTotal: 4 << 30},
+		System:      btrfs.Space{TotalBytes: 32 << 20, DiskTotal: 64 << 20},
+		DeviceBytes: (54 << 30) + (64 << 20),
+	}
+
+	criticals, warnings := evaluateBtrfs("/", allocation, thresholds)
+	if len(criticals) != 1 || criticals[0] != "/ at 100.00% btrfs metadata usage (0.00 B unallocated)" {
+		t.Errorf("expected metadata critical, got %v", criticals)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", warnings)
+	}
+}
+
+func TestMissingMounts(t *testing.T) {
+	partitions := []disk.PartitionStat{
+		{Mountpoint: "/", Fstype: "ext4"},
+		{Mountpoint: "/data", Fstype: "xfs"},
+		{Mountpoint: "/proc", Fstype: "proc"},
+	}
+
+	tests := []struct {
+		name         string
+		includePaths []string
+		want         []string
+	}{
+		{"present", []string{"/data", "/proc"}, nil},
+		{"missing", []string{"/data", "/backup"}, []string{"/backup"}},
+		{"glob", []string{"/mnt/*"}, nil},
+		{"regex", []string{"~^/srv/"}, nil},
+		{"mixed", []string{"/srv/*", "/backup", "~^/data$"}, []string{"/backup"}},
+	}
+	for _, tt := range tests {
+		got := missingMounts(partitions, tt.includePaths)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: missingMounts() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHasLiteral(t *testing.T) {
+	if hasLiteral(nil) || hasLiteral([]string{"/mnt/*", "~^/srv/"}) {
+		t.Error("expected only patterns to have no literal path")
+	}
+	if !hasLiteral([]string{"/mnt/*", "/data"}) {
+		t.Error("expected /data to be a literal path")
+	}
+}
+
+func TestEvaluateResult_Severities(t *testing.T) {
+	plugin.Warning = 80
+	plugin.Critical = 90
+	plugin.Btrfs = true
+	defer func() { plugin.Btrfs = false }()
+	// No btrfs allocation can be read from an empty sysfs
+	t.Setenv("HOST_SYS", t.TempDir())
+
+	failed := statfs.Result{
+		Partition: disk.PartitionStat{Mountpoint: "/mnt/nas", Fstype: "nfs4"},
+		Err:       errors.New("stale NFS file handle"),
+	}
+	timedOut := statfs.Result{
+		Partition: disk.PartitionStat{Mountpoint: "/mnt/nas", Fstype: "nfs4"},
+		Err:       statfs.ErrTimeout,
+	}
+	unreadableBtrfs := statfs.Result{
+		Partition: disk.PartitionStat{Mountpoint: "/data", Device: "/dev/sdb1", Fstype: "btrfs"},
+		Usage:     &disk.UsageStat{Total: 100 << 30, UsedPercent: 10},
+	}
+
+	tests := []struct {
+		name        string
+		result      statfs.Result
+		severity    string
+		wantMetrics bool
+		wantMsg     string
+	}{
+		{"statfs error", failed, severityWarning, false, "/mnt/nas could not be read: stale NFS file handle"},
+		{"statfs timeout", timedOut, severityCritical, false, "/mnt/nas did not respond within 5s"},
+		{"btrfs error", unreadableBtrfs, severityUnknown, true, "/data btrfs allocation could not be read: "},
+	}
+	for _, tt := range tests {
+		for _, severity := range severities {
+			plugin.ErrorSeverity, plugin.TimeoutSeverity = severity, severity
+
+			var f findings
+			_, ok := evaluateResult(&f, tt.result, 5*time.Second, nil, time.Now())
+			if ok != tt.wantMetrics {
+				t.Errorf("%s: evaluateResult() ok = %v, want %v", tt.name, ok, tt.wantMetrics)
+			}
+
+			got := map[string][]string{
+				severityCritical: f.criticals,
+				severityWarning:  f.warnings,
+				severityUnknown:  f.unknowns,
+			}
+			for s, msgs := range got {
+				want := 0
+				if s == severity {
+					want = 1
+				}
+				if len(msgs) != want {
+					t.Errorf("%s with severity %s: got %s messages %v", tt.name, severity, s, msgs)
+				} else if want == 1 && !strings.HasPrefix(msgs[0], tt.wantMsg) {
+					t.Errorf("%s with severity %s: got %q, want %q", tt.name, severity, msgs[0], tt.wantMsg)
+				}
+			}
+		}
+	}
+}
+
+func TestPrometheusLabels_Escaping(t *testing.T) {
+	m := mountMetrics{Mountpoint: "/mnt/my \"disk\"\\\tnew\nline", Fstype: "ext4"}
+	want := `mountpoint="/mnt/my \"disk\"\\` + "\t" + `new\nline",fstype="ext4"`
+	if got := prometheusLabels(m); got != want {
+		t.Errorf("prometheusLabels() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nmollerup/sensu-check-disk/internal/capacity"
+	"github.com/nmollerup/sensu-check-disk/internal/kubelet"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// Supported values of --metrics-format
+const (
+	metricsFormatNone       = "none"
+	metricsFormatNagios     = "nagios_perfdata"
+	metricsFormatPrometheus = "prometheus_text"
+)
+
+// mountMetrics holds what is needed to emit perfdata for one mountpoint
+type mountMetrics struct {
+	Mountpoint string
+	Fstype     string
+	Volume     *kubelet.Volume
+	Usage      *disk.UsageStat
+	Thresholds capacity.Thresholds
+}
+
+// withMetrics appends the metrics for the evaluated mountpoints to the status
+// line in the configured format
+func withMetrics(status string, metrics []mountMetrics) string {
+	if len(metrics) == 0 {
+		return status
+	}
+
+	switch plugin.MetricsFormat {
+	case metricsFormatNagios:
+		return status + " | " + formatNagiosPerfdata(metrics)
+	case metricsFormatPrometheus:
+		// The status line becomes a comment so the output stays valid
+		// Prometheus exposition text
+		return "# " + status + "\n" + formatPrometheus(metrics)
+	}
+	return status
+}
+
+// formatNagiosPerfdata renders the metrics as 'label'=value[UOM];warn;crit;min;max
+// pairs separated by spaces
+func formatNagiosPerfdata(metrics []mountMetrics) string {
+	var pairs []string
+	written := make(map[string]bool)
+	for _, m := range metrics {
+		label := perfdataLabel(m.Mountpoint)
+		if m.Volume != nil {
+			label = m.Volume.MetricPath()
+		}
+		// All mounts of a PV share its label, so write it only once
+		if m.Volume != nil {
+			if written[label] {
+				continue
+			}
+			written[label] = true
+		}
+		capacity := m.Usage.Used + m.Usage.Free
+		warning := scaling().Adjust(m.Usage.Total, m.Thresholds.Warning)
+		critical := scaling().Adjust(m.Usage.Total, m.Thresholds.Critical)
+
+		pairs = append(pairs,
+			fmt.Sprintf("%s_used=%dB;%d;%d;0;%d", label, m.Usage.Used,
+				uint64(float64(capacity)*warning/100), uint64(float64(capacity)*critical/100), m.Usage.Total),
+			fmt.Sprintf("%s_used_percent=%.2f%%;%.2f;%.2f;0;100", label, m.Usage.UsedPercent, warning, critical),
+		)
+
+		if m.Usage.InodesTotal > 0 {
+			inodesPercent := float64(m.Usage.InodesUsed) / float64(m.Usage.InodesTotal) * 100.0
+			pairs = append(pairs, fmt.Sprintf("%s_inodes_used_percent=%.2f%%;%s;%s;0;100", label, inodesPercent,
+				optionalThreshold(m.Thresholds.InodeWarning), optionalThreshold(m.Thresholds.InodeCritical)))
+		}
+	}
+	return strings.Join(pairs, " ")
+}
+
+// formatPrometheus renders the metrics in the Prometheus text exposition format
+func formatPrometheus(metrics []mountMetrics) string {
+	var b strings.Builder
+	gauge := func(name, help string, values func(m mountMetrics) (float64, bool)) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, m := range metrics {
+			if value, ok := values(m); ok {
+				fmt.Fprintf(&b, "%s{%s} %g\n", name, prometheusLabels(m), value)
+			}
+		}
+	}
+
+	gauge("disk_used_bytes", "Bytes used on the filesystem", func(m mountMetrics) (float64, bool) {
+		return float64(m.Usage.Used), true
+	})
+	gauge("disk_total_bytes", "Size of the filesystem in bytes", func(m mountMetrics) (float64, bool) {
+		return float64(m.Usage.Total), true
+	})
+	gauge("disk_used_percent", "Percentage of the filesystem used", func(m mountMetrics) (float64, bool) {
+		return m.Usage.UsedPercent, true
+	})
+	gauge("disk_warning_percent", "Warning threshold for disk usage", func(m mountMetrics) (float64, bool) {
+		return scaling().Adjust(m.Usage.Total, m.Thresholds.Warning), true
+	})
+	gauge("disk_critical_percent", "Critical threshold for disk usage", func(m mountMetrics) (float64, bool) {
+		return scaling().Adjust(m.Usage.Total, m.Thresholds.Critical), true
+	})
+	gauge("disk_inodes_used_percent", "Percentage of inodes used", func(m mountMetrics) (float64, bool) {
+		if m.Usage.InodesTotal == 0 {
+			return 0, false
+		}
+		return float64(m.Usage.InodesUsed) / float64(m.Usage.InodesTotal) * 100.0, true
+	})
+	gauge("disk_inodes_warning_percent", "Warning threshold for inode usage", func(m mountMetrics) (float64, bool) {
+		return m.Thresholds.InodeWarning, m.Usage.InodesTotal > 0 && m.Thresholds.InodeWarning > 0
+	})
+	gauge("disk_inodes_critical_percent", "Critical threshold for inode usage", func(m mountMetrics) (float64, bool) {
+		return m.Thresholds.InodeCritical, m.Usage.InodesTotal > 0 && m.Thresholds.InodeCritical > 0
+	})
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// prometheusLabels renders the label set of a mountpoint, adding the kubelet
+// volume labels when --kubernetes recognised one
+func prometheusLabels(m mountMetrics) string {
+	labels := fmt.Sprintf("mountpoint=%s,fstype=%s", labelValue(m.Mountpoint), labelValue(m.Fstype))
+	if m.Volume == nil {
+		return labels
+	}
+	volumeLabels := m.Volume.Labels()
+	for _, name := range kubelet.Labels {
+		if value, ok := volumeLabels[name]; ok {
+			labels += fmt.Sprintf(",%s=%s", name, labelValue(value))
+		}
+	}
+	return labels
+}
+
+// labelEscaper escapes the only characters the Prometheus text format
+// escapes in label values; anything else, such as a tab, is taken literally
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue quotes a Prometheus label value
+func labelValue(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+// perfdataLabel makes a mountpoint safe to use as a perfdata label, which may
+// not contain whitespace, '=' or '|'
+func perfdataLabel(mountpoint string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '=', '|', '\'':
+			return '_'
+		}
+		return r
+	}, mountpoint)
+}
+
+func optionalThreshold(value float64) string {
+	if value <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", value)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/nmollerup/sensu-check-disk/internal/capacity"
+	"github.com/nmollerup/sensu-check-disk/internal/kubelet"
+)
+
+func defaultThresholds() capacity.Thresholds {
+	return capacity.Thresholds{
+		Warning:       plugin.Warning,
+		Critical:      plugin.Critical,
+		InodeWarning:  plugin.InodeWarning,
+		InodeCritical: plugin.InodeCritical,
+		WarningFree:   plugin.warningFree,
+		CriticalFree:  plugin.criticalFree,
+	}
+}
+
+func scaling() capacity.Scaling {
+	return capacity.Scaling{
+		Magic:   plugin.Magic,
+		Normal:  plugin.Normal,
+		Minimum: plugin.Minimum,
+	}
+}
+
+// thresholdsFor returns the thresholds of the first rule matching the
+// partition, or the command-line thresholds if no rule matches
+func thresholdsFor(mountpoint, fstype string) capacity.Thresholds {
+	target := capacity.Target{Mountpoint: mountpoint, Fstype: fstype}
+	if volume, ok := kubelet.Parse(mountpoint); ok {
+		target.Labels = volume.Labels()
+	}
+	return capacity.For(rules, target, defaultThresholds())
+}
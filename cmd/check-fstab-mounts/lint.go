@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nmollerup/sensu-check-disk/internal/filter"
+	"github.com/nmollerup/sensu-check-disk/internal/fstab"
+	"github.com/nmollerup/sensu-check-disk/internal/hostroot"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// lintTypes are fstab types that are not kernel filesystems but are valid
+// all the same
+var lintTypes = map[string]bool{
+	"auto":   true,
+	"none":   true,
+	"ignore": true,
+	"swap":   true,
+}
+
+// knownFilesystems returns the filesystem types the running kernel supports,
+// listed in /proc/filesystems, together with the ones a module can be loaded
+// for on demand, which modules.alias lists as fs-<type>
+func knownFilesystems() (map[string]bool, error) {
+	known := make(map[string]bool)
+
+	file, err := os.Open(hostroot.Proc("filesystems"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Lines are "nodev\tsysfs" or "\text4"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 {
+			known[fields[len(fields)-1]] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	release, err := os.ReadFile(hostroot.Proc("sys", "kernel", "osrelease"))
+	if err != nil {
+		return known, nil
+	}
+	aliases, err := os.Open(hostroot.Path(filepath.Join("/lib/modules", strings.TrimSpace(string(release)), "modules.alias")))
+	if err != nil {
+		return known, nil
+	}
+	defer aliases.Close()
+
+	scanner = bufio.NewScanner(aliases)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "alias" && strings.HasPrefix(fields[1], "fs-") {
+			known[strings.TrimPrefix(fields[1], "fs-")] = true
+		}
+	}
+	return known, scanner.Err()
+}
+
+// knownFSType reports whether mount can mount the fstab type, which is the
+// case when the kernel supports it or a mount.<type> helper is installed.
+// Any of the types of a comma-separated list will do, and fuse.<subtype>
+// needs the kernel's fuse support.
+func knownFSType(fstype string, known map[string]bool) bool {
+	for _, t := range strings.Split(fstype, ",") {
+		if lintTypes[t] || known[t] {
+			return true
+		}
+		if strings.HasPrefix(t, "fuse.") && known["fuse"] {
+			return true
+		}
+		for _, dir := range []string{"/sbin", "/usr/sbin"} {
+			if _, err := os.Stat(hostroot.Path(filepath.Join(dir, "mount."+t))); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lintFstab returns the problems in an fstab file that would make the next
+// boot fail or hang, as criticals, and questionable settings, as warnings.
+// Problems with entries that are noauto or nofail, which do not hold up the
+// boot, are warnings as well.
+func lintFstab(entries []FstabEntry, malformed []fstab.SyntaxError, known map[string]bool) (criticals, warnings []string) {
+	type problem struct {
+		line     int
+		critical bool
+		msg      string
+	}
+	var problems []problem
+	for _, e := range malformed {
+		problems = append(problems, problem{e.Line, true, e.Error()})
+	}
+
+	seen := make(map[string]int)
+	for _, entry := range entries {
+		optional := entry.HasOption("noauto") || entry.HasOption("nofail")
+		add := func(critical bool, format string, args ...interface{}) {
+			msg := fmt.Sprintf("line %d: ", entry.Line) + fmt.Sprintf(format, args...)
+			problems = append(problems, problem{entry.Line, critical, msg})
+		}
+		report := func(format string, args ...interface{}) {
+			add(!optional, format, args...)
+		}
+		warn := func(format string, args ...interface{}) {
+			add(false, format, args...)
+		}
+
+		mountpoint := entry.MountPoint
+		swap := entry.FSType == "swap"
+		if !swap && mountpoint != "none" && mountpoint != "null" {
+			if !filepath.IsAbs(mountpoint) {
+				report("mountpoint %s is not an absolute path", mountpoint)
+			} else {
+				mountpoint = filepath.Clean(mountpoint)
+				if line, ok := seen[mountpoint]; ok {
+					report("%s is already declared on line %d", mountpoint, line)
+				} else {
+					seen[mountpoint] = entry.Line
+				}
+				if info, err := os.Stat(hostroot.Path(mountpoint)); err != nil {
+					report("mountpoint %s does not exist", mountpoint)
+				} else if !info.IsDir() && !isBind(entry) {
+					report("mountpoint %s is not a directory", mountpoint)
+				}
+			}
+		}
+
+		if _, err := fstab.Resolve(entry.Device); errors.Is(err, fstab.ErrNotDevice) {
+			// Bind mount sources, swap files and loop images must exist;
+			// //server/share is a CIFS share
+			if filepath.IsAbs(entry.Device) && !strings.HasPrefix(entry.Device, "//") {
+				if _, err := os.Stat(hostroot.Path(entry.Device)); err != nil {
+					report("%s does not exist", entry.Device)
+				}
+			}
+		} else if err != nil {
+			report("%v", err)
+		}
+
+		if !knownFSType(entry.FSType, known) {
+			report("unknown filesystem type %s", entry.FSType)
+		}
+
+		switch {
+		case entry.Pass < 0:
+			report("fsck pass %d is negative", entry.Pass)
+		case entry.Pass == 0:
+		case swap || isBind(entry) || filter.IsPseudo(entry.FSType) || filter.IsNetwork(entry.FSType):
+			warn("fsck pass %d is set, but fsck does not check %s", entry.Pass, fsckSubject(entry))
+		case mountpoint == "/" && entry.Pass != 1:
+			warn("/ has fsck pass %d, the root filesystem should have pass 1", entry.Pass)
+		case mountpoint != "/" && entry.Pass == 1:
+			warn("%s has fsck pass 1, which is meant for the root filesystem; other filesystems should have pass 2", mountpoint)
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].line < problems[j].line })
+	for _, p := range problems {
+		if p.critical {
+			criticals = append(criticals, p.msg)
+		} else {
+			warnings = append(warnings, p.msg)
+		}
+	}
+	return criticals, warnings
+}
+
+// fsckSubject names the kind of entry fsck skips
+func fsckSubject(entry FstabEntry) string {
+	switch {
+	case entry.FSType == "swap":
+		return "swap"
+	case isBind(entry):
+		return "bind mounts"
+	}
+	return entry.FSType + " filesystems"
+}
+
+func executeLint() (int, error) {
+	file, err := os.Open(plugin.FstabPath)
+	if err != nil {
+		return sensu.CheckStateCritical, fmt.Errorf("failed to parse fstab: %v", err)
+	}
+	defer file.Close()
+
+	entries, malformed, err := fstab.ParseStrict(file)
+	if err != nil {
+		return sensu.CheckStateCritical, fmt.Errorf("failed to parse fstab: %v", err)
+	}
+	known, err := knownFilesystems()
+	if err != nil {
+		return sensu.CheckStateCritical, fmt.Errorf("failed to read supported filesystems: %v", err)
+	}
+
+	criticals, warnings := lintFstab(entries, malformed, known)
+
+	if len(criticals) > 0 {
+		fmt.Printf("CRITICAL - Problems found in %s: %v\n", plugin.FstabPath, criticals)
+		return sensu.CheckStateCritical, nil
+	}
+
+	if len(warnings) > 0 {
+		fmt.Printf("WARNING - Problems found in %s: %v\n", plugin.FstabPath, warnings)
+		return sensu.CheckStateWarning, nil
+	}
+
+	fmt.Printf("OK - No problems found in %s\n", plugin.FstabPath)
+	return sensu.CheckStateOK, nil
+}
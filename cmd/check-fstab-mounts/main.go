@@ -1,20 +1,35 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
-	"os"
 	"strings"
 
+	"github.com/nmollerup/sensu-check-disk/internal/filter"
+	"github.com/nmollerup/sensu-check-disk/internal/fstab"
+	"github.com/nmollerup/sensu-check-disk/internal/hostroot"
+	"github.com/nmollerup/sensu-check-disk/internal/mountinfo"
+	"github.com/nmollerup/sensu-check-disk/internal/swaps"
 	corev2 "github.com/sensu/core/v2"
 	"github.com/sensu/sensu-plugin-sdk/sensu"
-	"github.com/shirou/gopsutil/v3/disk"
 )
 
 // Config represents the check plugin config
 type Config struct {
 	sensu.PluginConfig
 	FstabPath string
+	HostRoot  string
+
+	SecurityDriftSeverity string
+	AccessDriftSeverity   string
+	AtimeDriftSeverity    string
+	OtherDriftSeverity    string
+
+	ReportUnexpected bool
+	AllowMounts      []string
+	SystemdUnits     bool
+	UnitDirs         []string
+	Lint             bool
+	SwapSeverity     string
 }
 
 var (
@@ -35,14 +50,149 @@ var (
 			Usage:     "Path to fstab file",
 			Value:     &plugin.FstabPath,
 		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "HostRoot",
+			Argument: "host-root",
+			Env:      "HOST_ROOT",
+			Usage:    "Directory the host's root filesystem is mounted at when running in a container (e.g. /host)",
+			Value:    &plugin.HostRoot,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "SecurityDriftSeverity",
+			Argument: "security-drift-severity",
+			Default:  severityCritical,
+			Allow:    severities,
+			Usage:    "Severity of nodev, nosuid and noexec declared in fstab but missing from the live mount: ignore, warning, critical or unknown",
+			Value:    &plugin.SecurityDriftSeverity,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "AccessDriftSeverity",
+			Argument: "access-drift-severity",
+			Default:  severityWarning,
+			Allow:    severities,
+			Usage:    "Severity of a mount being ro or rw unlike fstab declares: ignore, warning, critical or unknown",
+			Value:    &plugin.AccessDriftSeverity,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "AtimeDriftSeverity",
+			Argument: "atime-drift-severity",
+			Default:  severityIgnore,
+			Allow:    severities,
+			Usage:    "Severity of noatime, relatime, strictatime and nodiratime differing between fstab and the live mount: ignore, warning, critical or unknown",
+			Value:    &plugin.AtimeDriftSeverity,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "OtherDriftSeverity",
+			Argument: "other-drift-severity",
+			Default:  severityIgnore,
+			Allow:    severities,
+			Usage:    "Severity of other per-mount options (nosymfollow, and nodev, nosuid and noexec that fstab does not declare) differing between fstab and the live mount: ignore, warning, critical or unknown",
+			Value:    &plugin.OtherDriftSeverity,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "ReportUnexpected",
+			Argument: "report-unexpected",
+			Default:  false,
+			Usage:    "Warn about mounted disks and network shares that are not declared in fstab",
+			Value:    &plugin.ReportUnexpected,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "AllowMounts",
+			Argument: "allow-mounts",
+			Usage:    "Comma-separated list of mountpoints not reported by --report-unexpected (globs and ~regex supported)",
+			Value:    &plugin.AllowMounts,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "SystemdUnits",
+			Argument: "systemd-units",
+			Default:  false,
+			Usage:    "Also check the mounts declared by systemd .mount and .automount units",
+			Value:    &plugin.SystemdUnits,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "UnitDirs",
+			Argument: "unit-dirs",
+			Default:  defaultUnitDirs,
+			Usage:    "Comma-separated list of systemd unit directories read by --systemd-units and --report-unexpected, in order of precedence",
+			Value:    &plugin.UnitDirs,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "SwapSeverity",
+			Argument: "swap-severity",
+			Default:  severityCritical,
+			Allow:    severities,
+			Usage:    "Severity of swap entries that are not active according to /proc/swaps: ignore, warning, critical or unknown",
+			Value:    &plugin.SwapSeverity,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "Lint",
+			Argument: "lint",
+			Default:  false,
+			Usage:    "Check the fstab file for mistakes that would break the next boot instead of checking the mounts",
+			Value:    &plugin.Lint,
+		},
 	}
 )
 
-type FstabEntry struct {
-	Device     string
-	MountPoint string
-	FSType     string
-	Options    string
+// Severities accepted by the --*-severity options
+const (
+	severityIgnore   = "ignore"
+	severityWarning  = "warning"
+	severityCritical = "critical"
+	severityUnknown  = "unknown"
+)
+
+var severities = []string{severityIgnore, severityWarning, severityCritical, severityUnknown}
+
+// FstabEntry is a parsed line of the fstab file
+type FstabEntry = fstab.Entry
+
+// sourceFstab is the source of declarations read from the fstab file
+const sourceFstab = "fstab"
+
+// declaration is a mount declared in fstab or by a systemd mount unit
+type declaration struct {
+	FstabEntry
+	// Source is fstab or the name of the mount unit
+	Source string
+	// Automount is set for mounts systemd only performs on first access;
+	// they are satisfied by the autofs trigger
+	Automount bool
+	// Optional is set for mounts that need not be mounted, such as noauto
+	// entries and units that are not enabled
+	Optional bool
+}
+
+// declarations returns the fstab entries followed by the mount units. A unit
+// replaces an fstab entry for the same mountpoint, as it does for systemd.
+func declarations(entries []FstabEntry, units []mountUnit) []declaration {
+	byUnit := make(map[string]bool)
+	for _, unit := range units {
+		byUnit[unit.Where] = true
+	}
+
+	var decls []declaration
+	for _, entry := range entries {
+		if byUnit[entry.MountPoint] {
+			continue
+		}
+		automount := entry.HasOption("x-systemd.automount")
+		decls = append(decls, declaration{
+			FstabEntry: entry,
+			Source:     sourceFstab,
+			Automount:  automount,
+			Optional:   entry.HasOption("noauto") && !automount,
+		})
+	}
+	for _, unit := range units {
+		decls = append(decls, declaration{
+			FstabEntry: FstabEntry{Device: unit.What, MountPoint: unit.Where, FSType: unit.Type, Options: unit.Options},
+			Source:     unit.Name,
+			Automount:  unit.Automount,
+			Optional:   !unit.Enabled || unit.Conditional,
+		})
+	}
+	return decls
 }
 
 func main() {
@@ -51,93 +201,180 @@ func main() {
 }
 
 func checkArgs(event *corev2.Event) (int, error) {
+	hostroot.Set(plugin.HostRoot)
+
+	// Read the host's fstab unless another file was given explicitly
+	if plugin.FstabPath == "/etc/fstab" {
+		plugin.FstabPath = hostroot.Etc("fstab")
+	}
+	if err := filter.Validate(plugin.AllowMounts); err != nil {
+		return sensu.CheckStateWarning, err
+	}
 	return sensu.CheckStateOK, nil
 }
 
+// driftSeverity returns the configured severity for mount option
+// differences of the category
+func driftSeverity(category string) string {
+	switch category {
+	case categorySecurity:
+		return plugin.SecurityDriftSeverity
+	case categoryAccess:
+		return plugin.AccessDriftSeverity
+	case categoryAtime:
+		return plugin.AtimeDriftSeverity
+	}
+	return plugin.OtherDriftSeverity
+}
+
+// skipMountpoint reports whether entries with the mountpoint are not checked:
+// comments, null mounts, and special entries
+func skipMountpoint(mountpoint string) bool {
+	return strings.HasPrefix(mountpoint, "#") || mountpoint == "" || mountpoint == "null"
+}
+
 func executeCheck(event *corev2.Event) (int, error) {
+	if plugin.Lint {
+		return executeLint()
+	}
+
 	// Parse fstab
-	entries, err := parseFstab(plugin.FstabPath)
+	entries, err := fstab.ParseFile(plugin.FstabPath)
 	if err != nil {
 		return sensu.CheckStateCritical, fmt.Errorf("failed to parse fstab: %v", err)
 	}
 
+	// Mount units always count as declarations for --report-unexpected, but
+	// are only verified with --systemd-units
+	var units []mountUnit
+	if plugin.SystemdUnits || plugin.ReportUnexpected {
+		units, err = loadMountUnits(plugin.UnitDirs)
+		if err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("failed to read systemd mount units: %v", err)
+		}
+	}
+	decls := declarations(entries, nil)
+	if plugin.SystemdUnits {
+		decls = declarations(entries, units)
+	}
+
 	// Get currently mounted filesystems
-	partitions, err := disk.Partitions(true)
+	mounts, err := mountinfo.Read()
 	if err != nil {
 		return sensu.CheckStateCritical, fmt.Errorf("failed to get mounted partitions: %v", err)
 	}
 
-	// Create a map of mounted paths
-	mounted := make(map[string]bool)
-	for _, partition := range partitions {
-		mounted[partition.Mountpoint] = true
+	var active []swaps.Swap
+	if plugin.SwapSeverity != severityIgnore {
+		active, err = swaps.Read()
+		if err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("failed to read active swap areas: %v", err)
+		}
 	}
 
-	// Check which fstab entries are not mounted
-	var unmounted []string
-	for _, entry := range entries {
-		// Skip swap, bind mounts, noauto, and special filesystems
-		if entry.FSType == "swap" || strings.Contains(entry.Options, "bind") || strings.Contains(entry.Options, "noauto") {
-			continue
-		}
+	// Map mounted paths to the filesystem visible there, which is the top
+	// one of any stacked on the same mountpoint
+	mounted := mountinfo.Visible(mounts)
 
-		// Skip comments, null mounts, and special entries
-		if strings.HasPrefix(entry.MountPoint, "#") || entry.MountPoint == "" || entry.MountPoint == "null" {
-			continue
-		}
+	var criticals []string
+	var warnings []string
+	var unknowns []string
 
-		// Check if mount point exists in mounted filesystems
-		if !mounted[entry.MountPoint] {
-			unmounted = append(unmounted, fmt.Sprintf("%s (%s)", entry.MountPoint, entry.Device))
+	report := func(severity, msg string) {
+		switch severity {
+		case severityCritical:
+			criticals = append(criticals, msg)
+		case severityWarning:
+			warnings = append(warnings, msg)
+		case severityUnknown:
+			unknowns = append(unknowns, msg)
 		}
 	}
 
-	if len(unmounted) > 0 {
-		fmt.Printf("CRITICAL - Filesystems not mounted: %v\n", unmounted)
-		return sensu.CheckStateCritical, nil
-	}
-
-	fmt.Println("OK - All fstab filesystems are mounted")
-	return sensu.CheckStateOK, nil
-}
+	// Check which fstab entries and units are not mounted as declared
+	for _, decl := range decls {
+		entry := decl.FstabEntry
 
-func parseFstab(path string) ([]FstabEntry, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
+		// Skip noauto entries and units that need not be active
+		if decl.Optional {
+			continue
+		}
 
-	var entries []FstabEntry
-	scanner := bufio.NewScanner(file)
+		// Swap is looked up among the active swap areas
+		if entry.FSType == "swap" {
+			if problem := verifySwap(entry, active); problem != "" {
+				report(plugin.SwapSeverity, problem)
+			}
+			continue
+		}
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+		if skipMountpoint(entry.MountPoint) {
+			continue
+		}
 
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
+		// Check if mount point exists in mounted filesystems
+		mount, ok := mounted[entry.MountPoint]
+		if !ok {
+			source := entry.Device
+			if decl.Source != sourceFstab {
+				source += ", " + decl.Source
+			}
+			criticals = append(criticals, fmt.Sprintf("%s (%s) not mounted", entry.MountPoint, source))
 			continue
 		}
 
-		// Split by whitespace
-		fields := strings.Fields(line)
-		if len(fields) < 4 {
+		// An automount is satisfied by its trigger until first accessed
+		if decl.Automount && mount.FSType == "autofs" {
 			continue
 		}
 
-		entry := FstabEntry{
-			Device:     fields[0],
-			MountPoint: fields[1],
-			FSType:     fields[2],
-			Options:    fields[3],
+		// Check that it is served by the declared device and type, or shows
+		// the declared directory for bind mounts, whose flags start out as
+		// the ones of the mount holding that directory
+		var declared mountFlags
+		if isBind(entry) {
+			origin, root, ok := bindOrigin(entry, mounted)
+			if !ok {
+				continue
+			}
+			if problem := verifyBind(entry, mount, origin, root, decl.Source); problem != "" {
+				criticals = append(criticals, problem)
+				continue
+			}
+			declared = bindFlags(liveFlags(origin.Options), entry.OptionList())
+		} else {
+			if problem := verifyMount(entry, mount, decl.Source); problem != "" {
+				criticals = append(criticals, problem)
+				continue
+			}
+			declared = declaredFlags(entry.FSType, entry.OptionList())
 		}
 
-		entries = append(entries, entry)
+		// Check that the live mount options match the declared ones
+		for _, d := range compareFlags(entry.MountPoint, declared, liveFlags(mount.Options), decl.Source) {
+			report(driftSeverity(d.Category), d.Message)
+		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	if plugin.ReportUnexpected {
+		warnings = append(warnings, unexpectedMounts(mounts, declarations(entries, units), plugin.AllowMounts)...)
 	}
 
-	return entries, nil
+	if len(criticals) > 0 {
+		fmt.Printf("CRITICAL - Filesystems not mounted as declared in fstab: %v\n", criticals)
+		return sensu.CheckStateCritical, nil
+	}
+
+	if len(unknowns) > 0 {
+		fmt.Printf("UNKNOWN - Filesystems not mounted as declared in fstab: %v\n", unknowns)
+		return sensu.CheckStateUnknown, nil
+	}
+
+	if len(warnings) > 0 {
+		fmt.Printf("WARNING - Filesystems not mounted as declared in fstab: %v\n", warnings)
+		return sensu.CheckStateWarning, nil
+	}
+
+	fmt.Println("OK - All fstab filesystems are mounted")
+	return sensu.CheckStateOK, nil
 }
@@ -1,72 +1,350 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/nmollerup/sensu-check-disk/internal/devtest"
+	"github.com/nmollerup/sensu-check-disk/internal/fstab"
+	"github.com/nmollerup/sensu-check-disk/internal/mountinfo"
+	"github.com/nmollerup/sensu-check-disk/internal/swaps"
 )
 
-// shouldSkipEntry determines if an fstab entry should be skipped during mount checking
-func shouldSkipEntry(entry FstabEntry) bool {
-	// Skip swap, bind mounts, noauto, and special filesystems
-	if entry.FSType == "swap" {
-		return true
+func TestSkipMountpoint(t *testing.T) {
+	tests := []struct {
+		mountpoint string
+		want       bool
+	}{
+		{"/", false},
+		{"/mnt/data", false},
+		{"null", true},
+		{"", true},
+		{"#/mnt/data", true},
+	}
+	for _, tt := range tests {
+		if got := skipMountpoint(tt.mountpoint); got != tt.want {
+			t.Errorf("skipMountpoint(%q) = %v, want %v", tt.mountpoint, got, tt.want)
+		}
 	}
+}
+
+func TestVerifyMount(t *testing.T) {
+	devtest.FakeDev(t, map[string]string{
+		"disk/by-uuid/data-uuid": "../../sdb1",
+		"mapper/vg-srv":          "../dm-0",
+	}, "sda1")
 
-	// Skip comments, null mounts, and special entries
-	if entry.MountPoint == "null" || entry.MountPoint == "" || len(entry.MountPoint) > 0 && entry.MountPoint[0] == '#' {
-		return true
+	tests := []struct {
+		name  string
+		entry FstabEntry
+		mount mountinfo.Mount
+		ok    bool
+	}{
+		{"same device by UUID",
+			FstabEntry{Device: "UUID=data-uuid", MountPoint: "/data", FSType: "xfs"},
+			mountinfo.Mount{Source: "/dev/sdb1", Mountpoint: "/data", FSType: "xfs"}, true},
+		{"mapper symlink",
+			FstabEntry{Device: "/dev/mapper/vg-srv", MountPoint: "/srv", FSType: "ext4"},
+			mountinfo.Mount{Source: "/dev/dm-0", Mountpoint: "/srv", FSType: "ext4"}, true},
+		{"other device mounted",
+			FstabEntry{Device: "UUID=data-uuid", MountPoint: "/data", FSType: "xfs"},
+			mountinfo.Mount{Source: "/dev/sda1", Mountpoint: "/data", FSType: "xfs"}, false},
+		{"declared device missing",
+			FstabEntry{Device: "UUID=gone", MountPoint: "/data", FSType: "xfs"},
+			mountinfo.Mount{Source: "/dev/sda1", Mountpoint: "/data", FSType: "xfs"}, false},
+		{"other fstype",
+			FstabEntry{Device: "UUID=data-uuid", MountPoint: "/data", FSType: "ext4"},
+			mountinfo.Mount{Source: "/dev/sdb1", Mountpoint: "/data", FSType: "xfs"}, false},
+		{"nfs mounted as nfs4",
+			FstabEntry{Device: "nas:/export", MountPoint: "/mnt/nas", FSType: "nfs"},
+			mountinfo.Mount{Source: "nas:/export", Mountpoint: "/mnt/nas", FSType: "nfs4"}, true},
+		{"fuse subtype",
+			FstabEntry{Device: "sshfs#backup@host:", MountPoint: "/mnt/backup", FSType: "fuse"},
+			mountinfo.Mount{Source: "backup@host:", Mountpoint: "/mnt/backup", FSType: "fuse.sshfs"}, true},
+		{"auto",
+			FstabEntry{Device: "UUID=data-uuid", MountPoint: "/data", FSType: "auto"},
+			mountinfo.Mount{Source: "/dev/sdb1", Mountpoint: "/data", FSType: "vfat"}, true},
+		{"unresolvable live device",
+			FstabEntry{Device: "UUID=data-uuid", MountPoint: "/", FSType: "ext4"},
+			mountinfo.Mount{Source: "/dev/root", Mountpoint: "/", FSType: "ext4"}, true},
 	}
 
-	return false
+	for _, tt := range tests {
+		problem := verifyMount(tt.entry, tt.mount, sourceFstab)
+		if (problem == "") != tt.ok {
+			t.Errorf("%s: verifyMount() = %q, want ok=%v", tt.name, problem, tt.ok)
+		}
+	}
 }
 
-func TestShouldSkipEntry_NullMountpoint(t *testing.T) {
-	entry := FstabEntry{
-		Device:     "/dev/sda1",
-		MountPoint: "null",
-		FSType:     "ext4",
-		Options:    "defaults",
+func TestCompareFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		fstype   string
+		declared string
+		live     string
+		want     []drift
+	}{
+		{"defaults", "ext4", "defaults", "rw,relatime", nil},
+		{"hardened", "ext4", "defaults,nodev,nosuid,noexec", "rw,nosuid,nodev,noexec,relatime", nil},
+		{"remounted exec", "ext4", "nodev,nosuid,noexec", "rw,nosuid,nodev,relatime",
+			[]drift{{categorySecurity, "/tmp is mounted without noexec"}}},
+		{"later option wins", "ext4", "noexec,exec", "rw,relatime", nil},
+		{"user implies nosuid,nodev,noexec", "vfat", "user,noauto", "rw,nosuid,nodev,noexec,relatime", nil},
+		{"user overridden", "vfat", "users,exec", "rw,nosuid,nodev,relatime", nil},
+		{"remounted read-only", "ext4", "errors=remount-ro", "ro,relatime",
+			[]drift{{categoryAccess, "/tmp is mounted ro, fstab declares rw"}}},
+		{"read-only type", "iso9660", "defaults", "ro,relatime", nil},
+		{"atime", "xfs", "noatime", "rw,relatime",
+			[]drift{{categoryAtime, "/tmp is mounted with relatime, fstab declares noatime"}}},
+		{"strictatime", "xfs", "strictatime", "rw", nil},
+		{"extra nodev", "ext4", "defaults", "rw,nodev,relatime",
+			[]drift{{categoryOther, "/tmp is mounted with nodev, which fstab does not declare"}}},
 	}
 
-	if !shouldSkipEntry(entry) {
-		t.Error("expected entry with 'null' mountpoint to be skipped")
+	for _, tt := range tests {
+		declared := declaredFlags(tt.fstype, FstabEntry{Options: tt.declared}.OptionList())
+		got := compareFlags("/tmp", declared, liveFlags(strings.Split(tt.live, ",")), sourceFstab)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: compareFlags() = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: compareFlags()[%d] = %v, want %v", tt.name, i, got[i], tt.want[i])
+			}
+		}
 	}
 }
 
-func TestShouldSkipEntry_ValidMountpoint(t *testing.T) {
-	entry := FstabEntry{
-		Device:     "/dev/sda1",
-		MountPoint: "/",
-		FSType:     "ext4",
-		Options:    "defaults",
+func TestVerifyBind(t *testing.T) {
+	visible := map[string]mountinfo.Mount{
+		"/":     {Major: 8, Minor: 1, Root: "/", Mountpoint: "/", FSType: "ext4", Source: "/dev/sda1"},
+		"/data": {Major: 8, Minor: 17, Root: "/", Mountpoint: "/data", FSType: "xfs", Source: "/dev/sdb1", Options: []string{"rw", "nosuid", "noatime"}},
+	}
+	entry := FstabEntry{Device: "/data/www", MountPoint: "/srv/www", FSType: "none", Options: "bind,nodev"}
+
+	origin, root, ok := bindOrigin(entry, visible)
+	if !ok || origin.Mountpoint != "/data" || root != "/www" {
+		t.Fatalf("bindOrigin() = %+v, %q, %v", origin, root, ok)
+	}
+
+	bound := mountinfo.Mount{Major: 8, Minor: 17, Root: "/www", Mountpoint: "/srv/www", FSType: "xfs", Source: "/dev/sdb1"}
+	if problem := verifyBind(entry, bound, origin, root, sourceFstab); problem != "" {
+		t.Errorf("verifyBind() = %q, want no problem", problem)
+	}
+
+	want := "/srv/www is served by /dev/sdb1[/old] (xfs), fstab declares a bind mount of /data/www (/dev/sdb1[/www])"
+	other := bound
+	other.Root = "/old"
+	if problem := verifyBind(entry, other, origin, root, sourceFstab); problem != want {
+		t.Errorf("verifyBind() = %q, want %q", problem, want)
+	}
+
+	other = mountinfo.Mount{Major: 8, Minor: 1, Root: "/", Mountpoint: "/srv/www", FSType: "ext4", Source: "/dev/sda1"}
+	if problem := verifyBind(entry, other, origin, root, sourceFstab); problem == "" {
+		t.Error("expected a problem for the directory left unmounted on the root filesystem")
 	}
 
-	if shouldSkipEntry(entry) {
-		t.Error("expected entry with valid mountpoint '/' to not be skipped")
+	// Flags not named in fstab are inherited from the mount holding the source
+	declared := bindFlags(liveFlags(origin.Options), entry.OptionList())
+	if drifts := compareFlags("/srv/www", declared, liveFlags([]string{"rw", "nosuid", "nodev", "noatime"}), sourceFstab); len(drifts) != 0 {
+		t.Errorf("unexpected drift %v", drifts)
+	}
+	if drifts := compareFlags("/srv/www", declared, liveFlags([]string{"rw", "nosuid", "noatime"}), sourceFstab); len(drifts) != 1 {
+		t.Errorf("expected missing nodev to drift, got %v", drifts)
+	}
+
+	if isBind(FstabEntry{Options: "x-bind-foo"}) || !isBind(FstabEntry{Options: "rbind"}) {
+		t.Error("expected only bind and rbind to declare bind mounts")
 	}
 }
 
-func TestShouldSkipEntry_EmptyMountpoint(t *testing.T) {
-	entry := FstabEntry{
-		Device:     "/dev/sda1",
-		MountPoint: "",
-		FSType:     "ext4",
-		Options:    "defaults",
+func TestUnexpectedMounts(t *testing.T) {
+	decls := declarations([]FstabEntry{
+		{Device: "UUID=root", MountPoint: "/", FSType: "ext4"},
+		{Device: "nas:/export", MountPoint: "/mnt/nas", FSType: "nfs"},
+	}, nil)
+	mounts := []mountinfo.Mount{
+		{Source: "/dev/sda1", Mountpoint: "/", FSType: "ext4"},
+		{Source: "proc", Mountpoint: "/proc", FSType: "proc"},
+		{Source: "tmpfs", Mountpoint: "/run", FSType: "tmpfs"},
+		{Source: "nas:/export", Mountpoint: "/mnt/nas", FSType: "nfs4"},
+		{Source: "/dev/sdc1", Mountpoint: "/media/usb", FSType: "vfat"},
+		{Source: "/dev/sdc1", Mountpoint: "/media/usb", FSType: "vfat"},
+		{Source: "other:/share", Mountpoint: "/mnt/share", FSType: "cifs"},
+		{Source: "/dev/rbd0", Mountpoint: "/var/lib/kubelet/pods/x/volumes/kubernetes.io~csi/pvc-1/mount", FSType: "ext4"},
 	}
 
-	if !shouldSkipEntry(entry) {
-		t.Error("expected entry with empty mountpoint to be skipped")
+	got := unexpectedMounts(mounts, decls, []string{"/var/lib/kubelet/*"})
+	want := []string{
+		"/media/usb (/dev/sdc1, vfat) is not declared in fstab",
+		"/mnt/share (other:/share, cifs) is not declared in fstab",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpectedMounts() = %v, want %v", got, want)
 	}
 }
 
-func TestShouldSkipEntry_CommentMountpoint(t *testing.T) {
-	entry := FstabEntry{
-		Device:     "/dev/sda1",
-		MountPoint: "#/mnt/data",
-		FSType:     "ext4",
-		Options:    "defaults",
+func TestLoadMountUnits(t *testing.T) {
+	etc := t.TempDir()
+	lib := t.TempDir()
+	write := func(dir, name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(dir, wants, name string) {
+		if err := os.MkdirAll(filepath.Join(dir, wants), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(dir, name), filepath.Join(dir, wants, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(etc, "data.mount", "[Unit]\nDescription=Data\n\n[Mount]\nWhat=/dev/disk/by-uuid/abc\nWhere=/data\nType=xfs\nOptions=nodev,\\\n  noexec\n\n[Install]\nWantedBy=local-fs.target\n")
+	link(etc, "local-fs.target.wants", "data.mount")
+	write(etc, "backup.mount", "[Mount]\nWhat=nas:/backup\nWhere=/mnt/backup\nType=nfs\n")
+	write(etc, "backup.automount", "[Automount]\nWhere=/mnt/backup\n")
+	link(etc, "remote-fs.target.wants", "backup.automount")
+	write(etc, "spare.mount", "[Mount]\nWhat=/dev/sdc1\nWhere=/spare\n")
+	if err := os.Symlink("/dev/null", filepath.Join(etc, "tmp.mount")); err != nil {
+		t.Fatal(err)
+	}
+	write(lib, "tmp.mount", "[Mount]\nWhat=tmpfs\nWhere=/tmp\nType=tmpfs\n")
+	link(lib, "local-fs.target.wants", "tmp.mount")
+	write(lib, "data.mount", "[Mount]\nWhat=/dev/sdz1\nWhere=/vendor-data\n")
+	write(lib, "sys-fs-fuse-connections.mount", "[Unit]\nConditionPathExists=/sys/fs/fuse/connections\n[Mount]\nWhat=fusectl\nWhere=/sys/fs/fuse/connections\nType=fusectl\n")
+	link(lib, "sysinit.target.wants", "sys-fs-fuse-connections.mount")
+
+	units, err := loadMountUnits([]string{etc, lib})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	if !shouldSkipEntry(entry) {
-		t.Error("expected entry with mountpoint starting with '#' to be skipped")
+	want := []mountUnit{
+		{Name: "data.mount", What: "/dev/disk/by-uuid/abc", Where: "/data", Type: "xfs", Options: "nodev, noexec", Enabled: true},
+		{Name: "backup.mount", What: "nas:/backup", Where: "/mnt/backup", Type: "nfs", Enabled: true, Automount: true},
+		{Name: "spare.mount", What: "/dev/sdc1", Where: "/spare"},
+		{Name: "sys-fs-fuse-connections.mount", What: "fusectl", Where: "/sys/fs/fuse/connections", Type: "fusectl", Enabled: true, Conditional: true},
+	}
+	if len(units) != len(want) {
+		t.Fatalf("loadMountUnits() = %+v, want %+v", units, want)
+	}
+	for i := range want {
+		if units[i] != want[i] {
+			t.Errorf("unit %d = %+v, want %+v", i, units[i], want[i])
+		}
+	}
+
+	decls := declarations([]FstabEntry{
+		{Device: "/dev/sdb1", MountPoint: "/data", FSType: "ext4"},
+		{Device: "/dev/sdd1", MountPoint: "/archive", FSType: "ext4", Options: "noauto,x-systemd.automount"},
+		{Device: "/dev/sde1", MountPoint: "/usb", FSType: "vfat", Options: "noauto"},
+	}, units)
+	got := make(map[string]declaration)
+	for _, decl := range decls {
+		got[decl.MountPoint] = decl
+	}
+	if flags := declaredFlags("xfs", got["/data"].OptionList()); !flags.NoDev || !flags.NoExec {
+		t.Errorf("expected options joined from continuation lines, got %+v", flags)
+	}
+	if len(decls) != 6 || got["/data"].Source != "data.mount" {
+		t.Errorf("expected data.mount to replace the fstab entry for /data, got %+v", decls)
+	}
+	if d := got["/archive"]; !d.Automount || d.Optional {
+		t.Errorf("expected x-systemd.automount entry to be a required automount, got %+v", d)
+	}
+	if !got["/usb"].Optional || !got["/spare"].Optional || got["/mnt/backup"].Optional {
+		t.Errorf("unexpected optional flags: %+v", decls)
+	}
+}
+
+func TestLintFstab(t *testing.T) {
+	devtest.FakeDev(t, map[string]string{"disk/by-uuid/root-uuid": "../../sda1"})
+
+	dir := t.TempDir()
+	for _, sub := range []string{"data", "srv", "www"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := strings.NewReplacer("DIR", dir).Replace(`UUID=root-uuid / ext4 defaults 0 1
+UUID=gone DIR/data xfs defaults 0 2
+/dev/sda1 DIR/data ext4 defaults 0 1
+DIR/www DIR/srv none bind 0 2
+UUID=gone DIR/missing bogusfs nofail 0 2
+/dev/sdb1 DIR/broken
+nas:/export DIR/srv nfs4 _netdev 0 0
+`)
+	entries, malformed, err := fstab.ParseStrict(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	known := map[string]bool{"ext4": true, "xfs": true, "nfs4": true}
+	criticals, warnings := lintFstab(entries, malformed, known)
+
+	wantCriticals := []string{
+		"line 2: UUID=gone does not exist",
+		"line 3: " + dir + "/data is already declared on line 2",
+		"line 6: has 2 fields, want 4 to 6",
+		"line 7: " + dir + "/srv is already declared on line 4",
+	}
+	wantWarnings := []string{
+		"line 3: " + dir + "/data has fsck pass 1, which is meant for the root filesystem; other filesystems should have pass 2",
+		"line 4: fsck pass 2 is set, but fsck does not check bind mounts",
+		"line 5: mountpoint " + dir + "/missing does not exist",
+		"line 5: UUID=gone does not exist",
+		"line 5: unknown filesystem type bogusfs",
+	}
+	if strings.Join(criticals, "\n") != strings.Join(wantCriticals, "\n") {
+		t.Errorf("lintFstab() criticals = %q, want %q", criticals, wantCriticals)
+	}
+	if strings.Join(warnings, "\n") != strings.Join(wantWarnings, "\n") {
+		t.Errorf("lintFstab() warnings = %q, want %q", warnings, wantWarnings)
+	}
+
+	if !knownFSType("fuse.sshfs", map[string]bool{"fuse": true}) || !knownFSType("bogusfs,ext4", known) || knownFSType("bogusfs", known) {
+		t.Error("unexpected knownFSType() result")
+	}
+}
+
+func TestVerifySwap(t *testing.T) {
+	devtest.FakeDev(t, map[string]string{
+		"disk/by-uuid/swap-uuid": "../../sda2",
+		"disk/by-label/spare":    "../../sdb2",
+		"mapper/vg-swap":         "../dm-1",
+	})
+
+	active := []swaps.Swap{
+		{Filename: "/dev/sda2", Type: "partition"},
+		{Filename: "/dev/dm-1", Type: "partition"},
+		{Filename: "/swapfile", Type: "file"},
+		{Filename: "/old.swap", Type: "file", Deleted: true},
+		{Filename: "/swap.img", Type: "file", Deleted: true},
+		{Filename: "/swap.img", Type: "file"},
+	}
+	tests := []struct {
+		device string
+		want   string
+	}{
+		{"UUID=swap-uuid", ""},
+		{"/dev/mapper/vg-swap", ""},
+		{"/swapfile", ""},
+		{"LABEL=spare", "swap LABEL=spare (/dev/sdb2) is not active"},
+		{"UUID=gone", "swap UUID=gone (not found) is not active"},
+		{"/var/swap", "swap /var/swap is not active"},
+		{"/old.swap", "swap /old.swap is active but its file was deleted"},
+		{"/swap.img", ""},
+	}
+	for _, tt := range tests {
+		entry := FstabEntry{Device: tt.device, MountPoint: "none", FSType: "swap", Options: "sw"}
+		if got := verifySwap(entry, active); got != tt.want {
+			t.Errorf("verifySwap(%s) = %q, want %q", tt.device, got, tt.want)
+		}
 	}
 }
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Categories of mount options, each with its own --*-drift-severity
+const (
+	categoryAccess   = "access"
+	categorySecurity = "security"
+	categoryAtime    = "atime"
+	categoryOther    = "other"
+)
+
+// readOnlyTypes are always mounted read-only by the kernel, whatever fstab
+// says
+var readOnlyTypes = map[string]bool{
+	"iso9660":  true,
+	"squashfs": true,
+	"erofs":    true,
+	"cramfs":   true,
+}
+
+// mountFlags are the per-mount flags the kernel reports for every mount,
+// independent of the filesystem type
+type mountFlags struct {
+	ReadOnly    bool
+	NoSuid      bool
+	NoDev       bool
+	NoExec      bool
+	Atime       string // noatime, relatime or strictatime
+	NoDiratime  bool
+	NoSymfollow bool
+}
+
+// drift is one difference between the declared and live mount flags
+type drift struct {
+	Category string
+	Message  string
+}
+
+// declaredFlags returns the flags a mount with the given fstab type and
+// options should have. Later options override earlier ones as they do for
+// mount(8), defaults and the user options expand to the flags they imply,
+// and the kernel's relatime default applies unless an atime option is given.
+func declaredFlags(fstype string, options []string) mountFlags {
+	flags := applyOptions(mountFlags{Atime: "relatime"}, options)
+	if readOnlyTypes[fstype] {
+		flags.ReadOnly = true
+	}
+	return flags
+}
+
+// bindFlags returns the flags a bind mount with the given fstab options
+// should have. A bind mount starts out with the flags of the mount holding
+// its source, and mount(8) only changes the ones the options name, so
+// defaults is not taken to reset them.
+func bindFlags(origin mountFlags, options []string) mountFlags {
+	var named []string
+	for _, option := range options {
+		if option != "defaults" {
+			named = append(named, option)
+		}
+	}
+	return applyOptions(origin, named)
+}
+
+// applyOptions returns flags changed by the fstab options in order
+func applyOptions(flags mountFlags, options []string) mountFlags {
+	for _, option := range options {
+		switch option {
+		case "defaults":
+			flags.ReadOnly, flags.NoSuid, flags.NoDev, flags.NoExec = false, false, false, false
+		case "ro", "rw":
+			flags.ReadOnly = option == "ro"
+		case "suid", "nosuid":
+			flags.NoSuid = option == "nosuid"
+		case "dev", "nodev":
+			flags.NoDev = option == "nodev"
+		case "exec", "noexec":
+			flags.NoExec = option == "noexec"
+		case "user", "users":
+			flags.NoSuid, flags.NoDev, flags.NoExec = true, true, true
+		case "owner", "group":
+			flags.NoSuid, flags.NoDev = true, true
+		case "noatime", "relatime", "strictatime":
+			flags.Atime = option
+		case "atime":
+			flags.Atime = "relatime"
+		case "diratime", "nodiratime":
+			flags.NoDiratime = option == "nodiratime"
+		case "symfollow", "nosymfollow":
+			flags.NoSymfollow = option == "nosymfollow"
+		}
+	}
+	return flags
+}
+
+// liveFlags returns the flags of a mount from its per-mount options as
+// listed in mountinfo, where the absence of noatime and relatime means
+// strictatime
+func liveFlags(options []string) mountFlags {
+	flags := mountFlags{Atime: "strictatime"}
+	for _, option := range options {
+		switch option {
+		case "ro":
+			flags.ReadOnly = true
+		case "nosuid":
+			flags.NoSuid = true
+		case "nodev":
+			flags.NoDev = true
+		case "noexec":
+			flags.NoExec = true
+		case "noatime", "relatime":
+			flags.Atime = option
+		case "nodiratime":
+			flags.NoDiratime = true
+		case "nosymfollow":
+			flags.NoSymfollow = true
+		}
+	}
+	return flags
+}
+
+// compareFlags lists how the live flags of the mount at mountpoint differ
+// from the ones declared in source, fstab or a mount unit
+func compareFlags(mountpoint string, declared, live mountFlags, source string) []drift {
+	var drifts []drift
+	add := func(category, format string, args ...interface{}) {
+		drifts = append(drifts, drift{Category: category, Message: mountpoint + " " + fmt.Sprintf(format, args...)})
+	}
+	toggle := func(category, option string, want, got bool) {
+		switch {
+		case want && !got:
+			add(category, "is mounted without %s", option)
+		case !want && got:
+			// A mount more restricted than declared is not a security
+			// problem, only losing nodev, nosuid or noexec is
+			if category == categorySecurity {
+				category = categoryOther
+			}
+			add(category, "is mounted with %s, which %s does not declare", option, source)
+		}
+	}
+
+	if declared.ReadOnly != live.ReadOnly {
+		add(categoryAccess, "is mounted %s, %s declares %s", accessMode(live.ReadOnly), source, accessMode(declared.ReadOnly))
+	}
+	toggle(categorySecurity, "nodev", declared.NoDev, live.NoDev)
+	toggle(categorySecurity, "nosuid", declared.NoSuid, live.NoSuid)
+	toggle(categorySecurity, "noexec", declared.NoExec, live.NoExec)
+	if declared.Atime != live.Atime {
+		add(categoryAtime, "is mounted with %s, %s declares %s", live.Atime, source, declared.Atime)
+	}
+	toggle(categoryAtime, "nodiratime", declared.NoDiratime, live.NoDiratime)
+	toggle(categoryOther, "nosymfollow", declared.NoSymfollow, live.NoSymfollow)
+
+	return drifts
+}
+
+func accessMode(readOnly bool) string {
+	if readOnly {
+		return "ro"
+	}
+	return "rw"
+}
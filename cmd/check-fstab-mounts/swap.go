@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/nmollerup/sensu-check-disk/internal/fstab"
+	"github.com/nmollerup/sensu-check-disk/internal/swaps"
+)
+
+// verifySwap checks that the swap entry is among the active swap areas. It
+// returns a description of the entry when it is not, or when the only active
+// swap at its path is a file that has since been deleted, or an empty string.
+// Devices are compared by the device node their spec resolves to, and swap
+// files by path.
+func verifySwap(entry FstabEntry, active []swaps.Swap) string {
+	declared := entry.Device
+	want, err := fstab.Resolve(entry.Device)
+	switch {
+	case errors.Is(err, fstab.ErrNotDevice):
+		want = filepath.Clean(entry.Device)
+	case err != nil:
+		return fmt.Sprintf("swap %s (not found) is not active", entry.Device)
+	case want != entry.Device:
+		declared += " (" + want + ")"
+	}
+
+	deleted := false
+	for _, swap := range active {
+		if swap.Deleted {
+			// The file is gone, so the swap area no longer backs the path
+			// fstab declares and will not be activated again on boot
+			deleted = deleted || swap.Filename == want
+			continue
+		}
+		if swap.Filename == want {
+			return ""
+		}
+		if got, err := fstab.Resolve(swap.Filename); err == nil && got == want {
+			return ""
+		}
+	}
+	if deleted {
+		return fmt.Sprintf("swap %s is active but its file was deleted", declared)
+	}
+	return fmt.Sprintf("swap %s is not active", declared)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nmollerup/sensu-check-disk/internal/filter"
+	"github.com/nmollerup/sensu-check-disk/internal/mountinfo"
+)
+
+// unexpectedMounts lists the mounted filesystems that are backed by a disk or
+// network share but not declared in fstab or a mount unit, leaving out
+// pseudo filesystems and mountpoints matching one of the allow patterns
+func unexpectedMounts(mounts []mountinfo.Mount, decls []declaration, allow []string) []string {
+	declared := make(map[string]bool)
+	for _, decl := range decls {
+		declared[decl.MountPoint] = true
+	}
+
+	var unexpected []string
+	seen := make(map[string]bool)
+	for _, mount := range mounts {
+		if seen[mount.Mountpoint] || declared[mount.Mountpoint] {
+			continue
+		}
+		seen[mount.Mountpoint] = true
+		if filter.IsPseudo(mount.FSType) || filter.MatchAny(allow, mount.Mountpoint) {
+			continue
+		}
+		unexpected = append(unexpected, fmt.Sprintf("%s (%s, %s) is not declared in fstab",
+			mount.Mountpoint, describeMount(mount), mount.FSType))
+	}
+	return unexpected
+}
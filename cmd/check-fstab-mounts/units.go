@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nmollerup/sensu-check-disk/internal/hostroot"
+)
+
+// defaultUnitDirs are the systemd system unit directories in order of
+// precedence. The generator directories are left out, since the units
+// systemd-fstab-generator writes there duplicate fstab.
+var defaultUnitDirs = []string{
+	"/etc/systemd/system",
+	"/run/systemd/system",
+	"/usr/local/lib/systemd/system",
+	"/usr/lib/systemd/system",
+	"/lib/systemd/system",
+}
+
+// mountUnit is a systemd .mount unit together with its .automount unit, if
+// there is one
+type mountUnit struct {
+	Name    string
+	What    string
+	Where   string
+	Type    string
+	Options string
+	// Enabled is set when the mount or automount unit is wanted or required
+	// by another unit
+	Enabled bool
+	// Automount is set when a matching .automount unit exists, so systemd
+	// only mounts the filesystem on first access
+	Automount bool
+	// Conditional is set when the mount or automount unit has Condition or
+	// Assert settings, so systemd may have skipped it on purpose
+	Conditional bool
+}
+
+// loadMountUnits reads the .mount and .automount units in dirs. A unit in an
+// earlier directory overrides units of the same name in later ones, and
+// masked units are left out.
+func loadMountUnits(dirs []string) ([]mountUnit, error) {
+	files := make(map[string]string)
+	for _, dir := range dirs {
+		paths, err := filepath.Glob(filepath.Join(hostroot.Path(dir), "*.*mount"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			name := filepath.Base(path)
+			if _, ok := files[name]; !ok {
+				files[name] = path
+			}
+		}
+	}
+
+	var units []mountUnit
+	for name, path := range files {
+		if !strings.HasSuffix(name, ".mount") || masked(path) {
+			continue
+		}
+		settings, err := parseUnit(path)
+		if err != nil {
+			return nil, err
+		}
+
+		unit := mountUnit{
+			Name:        name,
+			What:        settings["Mount.What"],
+			Where:       settings["Mount.Where"],
+			Type:        settings["Mount.Type"],
+			Options:     settings["Mount.Options"],
+			Enabled:     enabled(dirs, name),
+			Conditional: settings["conditional"] != "",
+		}
+		automount := strings.TrimSuffix(name, ".mount") + ".automount"
+		if path, ok := files[automount]; ok && !masked(path) {
+			settings, err := parseUnit(path)
+			if err != nil {
+				return nil, err
+			}
+			unit.Automount = true
+			unit.Enabled = unit.Enabled || enabled(dirs, automount)
+			unit.Conditional = unit.Conditional || settings["conditional"] != ""
+		}
+		if unit.Where != "" {
+			units = append(units, unit)
+		}
+	}
+
+	sort.Slice(units, func(i, j int) bool { return units[i].Where < units[j].Where })
+	return units, nil
+}
+
+// parseUnit returns the settings of a unit file keyed by section and name,
+// e.g. Mount.Where. Later assignments override earlier ones. The key
+// conditional is set when the unit has any Condition or Assert settings.
+func parseUnit(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	settings := make(map[string]string)
+	section := ""
+	scanner := bufio.NewScanner(file)
+	var line string
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+
+		// Join continuation lines
+		if strings.HasSuffix(text, `\`) {
+			line += strings.TrimSuffix(text, `\`) + " "
+			continue
+		}
+		line += text
+		text, line = strings.TrimSpace(line), ""
+
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section = text[1 : len(text)-1]
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if strings.HasPrefix(key, "Condition") || strings.HasPrefix(key, "Assert") {
+			settings["conditional"] = key
+		}
+		settings[section+"."+key] = strings.TrimSpace(value)
+	}
+	return settings, scanner.Err()
+}
+
+// enabled reports whether a .wants or .requires directory in dirs links to
+// the unit
+func enabled(dirs []string, name string) bool {
+	for _, dir := range dirs {
+		for _, pattern := range []string{"*.wants", "*.requires"} {
+			links, _ := filepath.Glob(filepath.Join(hostroot.Path(dir), pattern, name))
+			if len(links) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// masked reports whether the unit file is a symlink to /dev/null
+func masked(path string) bool {
+	target, err := os.Readlink(path)
+	return err == nil && target == "/dev/null"
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/nmollerup/sensu-check-disk/internal/btrfs"
+	"github.com/nmollerup/sensu-check-disk/internal/fstab"
+	"github.com/nmollerup/sensu-check-disk/internal/hostroot"
+	"github.com/nmollerup/sensu-check-disk/internal/mountinfo"
+)
+
+// fstypeAliases groups filesystem types that the kernel may report under a
+// different name than the one given to mount
+var fstypeAliases = map[string]string{
+	"nfs4": "nfs",
+	"smb3": "cifs",
+}
+
+// verifyMount compares the filesystem mounted at the entry's mountpoint with
+// the device and type the entry declares in source, fstab or a mount unit.
+// It returns a description of the difference, or an empty string when they
+// match or cannot be compared.
+func verifyMount(entry FstabEntry, mount mountinfo.Mount, source string) string {
+	typeOK := sameFSType(entry.FSType, mount.FSType)
+
+	deviceOK := true
+	declared := entry.FSType
+	want, err := fstab.Resolve(entry.Device)
+	switch {
+	case errors.Is(err, fstab.ErrNotDevice):
+		// NFS exports, tmpfs and ZFS datasets are only compared by type
+	case err != nil:
+		// The declared device is missing, so whatever is mounted is not it
+		deviceOK = false
+		declared = "not found, " + entry.FSType
+	default:
+		if want != entry.Device {
+			declared = want + ", " + entry.FSType
+		}
+		deviceOK = sameDevice(want, mount)
+	}
+
+	if typeOK && deviceOK {
+		return ""
+	}
+	return fmt.Sprintf("%s is served by %s (%s), %s declares %s (%s)",
+		entry.MountPoint, mount.Device(), mount.FSType, source, entry.Device, declared)
+}
+
+// sameFSType reports whether a filesystem mounted as live satisfies the
+// declared fstab type, which may be auto, a comma-separated list, or plain
+// fuse for any fuse.<subtype>
+func sameFSType(declared, live string) bool {
+	if declared == "" || declared == "auto" {
+		return true
+	}
+	for _, fstype := range strings.Split(declared, ",") {
+		if fstype == live || canonicalFSType(fstype) == canonicalFSType(live) {
+			return true
+		}
+		if fstype == "fuse" && strings.HasPrefix(live, "fuse.") {
+			return true
+		}
+	}
+	return false
+}
+
+func canonicalFSType(fstype string) string {
+	if alias, ok := fstypeAliases[fstype]; ok {
+		return alias
+	}
+	return fstype
+}
+
+// sameDevice reports whether the mount is served by the device node want.
+// Mounts whose device cannot be resolved, such as /dev/root, are given the
+// benefit of the doubt. A multi-device btrfs filesystem may be listed under
+// any of its member devices.
+func sameDevice(want string, mount mountinfo.Mount) bool {
+	got, err := fstab.Resolve(mount.Device())
+	if err != nil || got == want {
+		return true
+	}
+	if mount.FSType == "btrfs" {
+		wantUUID, err := btrfs.UUID(hostroot.Sys(), want)
+		if err != nil {
+			return false
+		}
+		gotUUID, err := btrfs.UUID(hostroot.Sys(), got)
+		return err == nil && gotUUID == wantUUID
+	}
+	return false
+}
+
+// isBind reports whether the entry declares a bind mount
+func isBind(entry FstabEntry) bool {
+	return entry.HasOption("bind") || entry.HasOption("rbind")
+}
+
+// bindOrigin returns the visible mount holding the source directory of a
+// bind mount entry, and the directory within that mount's filesystem a bind
+// mount of it shows
+func bindOrigin(entry FstabEntry, visible map[string]mountinfo.Mount) (mountinfo.Mount, string, bool) {
+	dir := entry.Device
+	if resolved, err := filepath.EvalSymlinks(hostroot.Path(dir)); err == nil {
+		dir = hostroot.Strip(resolved)
+	}
+	origin, rel, ok := mountinfo.Containing(visible, dir)
+	if !ok {
+		return mountinfo.Mount{}, "", false
+	}
+	return origin, filepath.Join(origin.Root, rel), true
+}
+
+// verifyBind checks that mount shows the directory root of the filesystem
+// origin is mounted from, as the bind mount entry declares in source. It
+// returns a description of the difference, or an empty string when they
+// match.
+func verifyBind(entry FstabEntry, mount, origin mountinfo.Mount, root, source string) string {
+	if mount.Major == origin.Major && mount.Minor == origin.Minor && mount.Root == root {
+		return ""
+	}
+	want := origin
+	want.Root = root
+	return fmt.Sprintf("%s is served by %s (%s), %s declares a bind mount of %s (%s)",
+		entry.MountPoint, describeMount(mount), mount.FSType, source, entry.Device, describeMount(want))
+}
+
+// describeMount names the source of a mount the way findmnt does, with the
+// directory within the filesystem in brackets unless it is the root
+func describeMount(mount mountinfo.Mount) string {
+	if mount.Root == "" || mount.Root == "/" {
+		return mount.Device()
+	}
+	return mount.Device() + "[" + mount.Root + "]"
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	corev2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// Config represents the check plugin config
+type Config struct {
+	sensu.PluginConfig
+	DataWarning      float64
+	DataCritical     float64
+	MetadataWarning  float64
+	MetadataCritical float64
+	VGWarning        float64
+	VGCritical       float64
+	LvsPath          string
+	VgsPath          string
+	InputFile        string
+}
+
+// Columns requested from lvs and vgs
+const (
+	lvsFields = "vg_name,lv_name,lv_attr,data_percent,metadata_percent"
+	vgsFields = "vg_name,vg_size,vg_free,vg_attr,vg_missing_pv_count"
+)
+
+// Report is the output of `lvs --reportformat json` or `vgs --reportformat
+// json`. `lvm fullreport --reportformat json` has both sections in one
+// report.
+type Report struct {
+	Report []struct {
+		LV []LV `json:"lv"`
+		VG []VG `json:"vg"`
+	} `json:"report"`
+}
+
+// LV is a row of the lvs report. lvs reports every value as a string.
+type LV struct {
+	VGName          string `json:"vg_name"`
+	LVName          string `json:"lv_name"`
+	LVAttr          string `json:"lv_attr"`
+	DataPercent     string `json:"data_percent"`
+	MetadataPercent string `json:"metadata_percent"`
+}
+
+// VG is a row of the vgs report. Unlike the volume group fields of lvs it
+// is listed for volume groups without any LVs too.
+type VG struct {
+	VGName           string `json:"vg_name"`
+	VGSize           string `json:"vg_size"`
+	VGFree           string `json:"vg_free"`
+	VGAttr           string `json:"vg_attr"`
+	VGMissingPVCount string `json:"vg_missing_pv_count"`
+}
+
+// IsThinPool reports whether the LV is a thin pool (volume type 't')
+func (lv LV) IsThinPool() bool {
+	return strings.HasPrefix(lv.LVAttr, "t")
+}
+
+// IsPartial reports whether the LV is missing some of its PVs
+func (lv LV) IsPartial() bool {
+	return len(lv.LVAttr) >= 9 && lv.LVAttr[8] == 'p'
+}
+
+var (
+	plugin = Config{
+		PluginConfig: sensu.PluginConfig{
+			Name:     "check-lvm-thinpool",
+			Short:    "Check LVM thin pool and volume group usage",
+			Keyspace: "",
+		},
+	}
+
+	options = []sensu.ConfigOption{
+		&sensu.PluginConfigOption[float64]{
+			Path:     "DataWarning",
+			Argument: "data-warning",
+			Default:  80,
+			Usage:    "Warning threshold percentage for thin pool data usage",
+			Value:    &plugin.DataWarning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "DataCritical",
+			Argument: "data-critical",
+			Default:  90,
+			Usage:    "Critical threshold percentage for thin pool data usage",
+			Value:    &plugin.DataCritical,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "MetadataWarning",
+			Argument: "metadata-warning",
+			Default:  80,
+			Usage:    "Warning threshold percentage for thin pool metadata usage",
+			Value:    &plugin.MetadataWarning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "MetadataCritical",
+			Argument: "metadata-critical",
+			Default:  90,
+			Usage:    "Critical threshold percentage for thin pool metadata usage",
+			Value:    &plugin.MetadataCritical,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "VGWarning",
+			Argument: "vg-warning",
+			Usage:    "Warning threshold percentage for volume group allocation (0 to disable)",
+			Value:    &plugin.VGWarning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "VGCritical",
+			Argument: "vg-critical",
+			Usage:    "Critical threshold percentage for volume group allocation (0 to disable)",
+			Value:    &plugin.VGCritical,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:      "LvsPath",
+			Argument:  "lvs-path",
+			Shorthand: "l",
+			Default:   "lvs",
+			Usage:     "Path to lvs binary",
+			Value:     &plugin.LvsPath,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "VgsPath",
+			Argument: "vgs-path",
+			Default:  "vgs",
+			Usage:    "Path to vgs binary",
+			Value:    &plugin.VgsPath,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:      "InputFile",
+			Argument:  "input-file",
+			Shorthand: "f",
+			Usage:     "Read recorded lvs and vgs JSON reports, one after the other, or an lvm fullreport JSON report from this file instead of running lvs and vgs",
+			Value:     &plugin.InputFile,
+		},
+	}
+)
+
+func main() {
+	check := sensu.NewCheck(&plugin.PluginConfig, options, checkArgs, executeCheck, false)
+	check.Execute()
+}
+
+func checkArgs(event *corev2.Event) (int, error) {
+	pairs := []struct {
+		name              string
+		warning, critical float64
+	}{
+		{"data", plugin.DataWarning, plugin.DataCritical},
+		{"metadata", plugin.MetadataWarning, plugin.MetadataCritical},
+		{"vg", plugin.VGWarning, plugin.VGCritical},
+	}
+	for _, p := range pairs {
+		if p.warning < 0 || p.critical < 0 {
+			return sensu.CheckStateWarning, fmt.Errorf("--%s-warning and --%s-critical must not be negative", p.name, p.name)
+		}
+		if p.warning > 0 && p.critical > 0 && p.warning >= p.critical {
+			return sensu.CheckStateWarning, fmt.Errorf("--%s-warning must be less than --%s-critical", p.name, p.name)
+		}
+	}
+	return sensu.CheckStateOK, nil
+}
+
+func executeCheck(event *corev2.Event) (int, error) {
+	var data []byte
+	var err error
+	if plugin.InputFile != "" {
+		data, err = os.ReadFile(plugin.InputFile)
+		if err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("failed to read input file: %v", err)
+		}
+	} else {
+		cmd := exec.Command(plugin.LvsPath, "--reportformat", "json", "--units", "b", "--nosuffix", "-a", "-o", lvsFields)
+		data, err = cmd.Output()
+		if err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("failed to run lvs: %v", err)
+		}
+		cmd = exec.Command(plugin.VgsPath, "--reportformat", "json", "--units", "b", "--nosuffix", "-o", vgsFields)
+		vgs, err := cmd.Output()
+		if err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("failed to run vgs: %v", err)
+		}
+		data = append(data, vgs...)
+	}
+
+	lvs, vgs, err := parseReports(data)
+	if err != nil {
+		return sensu.CheckStateCritical, fmt.Errorf("failed to parse lvm output: %v", err)
+	}
+
+	criticals, warnings := evaluate(lvs, vgs)
+
+	if len(criticals) > 0 {
+		fmt.Printf("CRITICAL - LVM problems: %v\n", criticals)
+		return sensu.CheckStateCritical, nil
+	}
+
+	if len(warnings) > 0 {
+		fmt.Printf("WARNING - LVM warnings: %v\n", warnings)
+		return sensu.CheckStateWarning, nil
+	}
+
+	fmt.Println("OK - All LVM thin pools and volume groups within thresholds")
+	return sensu.CheckStateOK, nil
+}
+
+// parseReports returns the LVs and VGs of one or more JSON reports following
+// each other in data
+func parseReports(data []byte) ([]LV, []VG, error) {
+	var lvs []LV
+	var vgs []VG
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var report Report
+		if err := decoder.Decode(&report); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		for _, r := range report.Report {
+			lvs = append(lvs, r.LV...)
+			vgs = append(vgs, r.VG...)
+		}
+	}
+	return lvs, vgs, nil
+}
+
+// evaluate checks thin pool data and metadata usage, volume group allocation
+// and missing or partial PVs
+func evaluate(lvs []LV, vgs []VG) (criticals []string, warnings []string) {
+	for _, lv := range lvs {
+		if lv.IsPartial() {
+			criticals = append(criticals, fmt.Sprintf("%s/%s is partial", lv.VGName, lv.LVName))
+		}
+
+		if lv.IsThinPool() {
+			crit, warn := evaluatePercent(fmt.Sprintf("thin pool %s/%s data", lv.VGName, lv.LVName), lv.DataPercent, plugin.DataWarning, plugin.DataCritical)
+			criticals = append(criticals, crit...)
+			warnings = append(warnings, warn...)
+
+			crit, warn = evaluatePercent(fmt.Sprintf("thin pool %s/%s metadata", lv.VGName, lv.LVName), lv.MetadataPercent, plugin.MetadataWarning, plugin.MetadataCritical)
+			criticals = append(criticals, crit...)
+			warnings = append(warnings, warn...)
+		}
+	}
+
+	for _, vg := range vgs {
+		if missing, _ := strconv.Atoi(vg.VGMissingPVCount); missing > 0 {
+			criticals = append(criticals, fmt.Sprintf("volume group %s has %d missing PVs", vg.VGName, missing))
+		} else if len(vg.VGAttr) >= 4 && vg.VGAttr[3] == 'p' {
+			criticals = append(criticals, fmt.Sprintf("volume group %s is partial", vg.VGName))
+		}
+
+		size, errSize := strconv.ParseFloat(vg.VGSize, 64)
+		free, errFree := strconv.ParseFloat(vg.VGFree, 64)
+		if errSize != nil || errFree != nil || size == 0 {
+			continue
+		}
+		usedPercent := (size - free) / size * 100.0
+		msg := fmt.Sprintf("volume group %s at %.2f%% allocated", vg.VGName, usedPercent)
+		if plugin.VGCritical > 0 && usedPercent >= plugin.VGCritical {
+			criticals = append(criticals, msg)
+		} else if plugin.VGWarning > 0 && usedPercent >= plugin.VGWarning {
+			warnings = append(warnings, msg)
+		}
+	}
+
+	return criticals, warnings
+}
+
+func evaluatePercent(name, value string, warning, critical float64) (criticals []string, warnings []string) {
+	percent, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		// lvs leaves the field empty while a pool is inactive
+		return nil, nil
+	}
+
+	msg := fmt.Sprintf("%s at %.2f%%", name, percent)
+	if critical > 0 && percent >= critical {
+		criticals = append(criticals, msg)
+	} else if warning > 0 && percent >= warning {
+		warnings = append(warnings, msg)
+	}
+	return criticals, warnings
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+)
+
+const lvsOutput = `{
+	"report": [
+		{
+			"lv": [
+				{"vg_name":"vg0", "lv_name":"pool0", "lv_attr":"twi-aotz--", "data_percent":"92.50", "metadata_percent":"41.00"},
+				{"vg_name":"vg0", "lv_name":"thin1", "lv_attr":"Vwi-aotz--", "data_percent":"80.00", "metadata_percent":""},
+				{"vg_name":"vg1", "lv_name":"pool1", "lv_attr":"twi-aotzp-", "data_percent":"10.00", "metadata_percent":"85.00"}
+			]
+		}
+	]
+}
+`
+
+// vg2 has no LVs, so lvs does not list it
+const vgsOutput = `{
+	"report": [
+		{
+			"vg": [
+				{"vg_name":"vg0", "vg_size":"107374182400", "vg_free":"5368709120", "vg_attr":"wz--n-", "vg_missing_pv_count":"0"},
+				{"vg_name":"vg1", "vg_size":"107374182400", "vg_free":"53687091200", "vg_attr":"wz-pn-", "vg_missing_pv_count":"1"},
+				{"vg_name":"vg2", "vg_size":"107374182400", "vg_free":"107374182400", "vg_attr":"wz-pn-", "vg_missing_pv_count":"1"}
+			]
+		}
+	]
+}
+`
+
+// fullreportOutput is an abbreviated `lvm fullreport --reportformat json`,
+// which has one report per VG
+const fullreportOutput = `{
+	"report": [
+		{
+			"vg": [
+				{"vg_name":"vg0", "vg_size":"107374182400", "vg_free":"5368709120", "vg_attr":"wz--n-", "vg_missing_pv_count":"0"}
+			],
+			"lv": [
+				{"vg_name":"vg0", "lv_name":"pool0", "lv_attr":"twi-aotz--", "data_percent":"92.50", "metadata_percent":"41.00"}
+			]
+		},
+		{
+			"vg": [
+				{"vg_name":"vg2", "vg_size":"107374182400", "vg_free":"107374182400", "vg_attr":"wz-pn-", "vg_missing_pv_count":"1"}
+			],
+			"lv": []
+		}
+	]
+}`
+
+func TestParseReports(t *testing.T) {
+	lvs, vgs, err := parseReports([]byte(lvsOutput + vgsOutput))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(lvs) != 3 {
+		t.Fatalf("expected 3 LVs, got %d", len(lvs))
+	}
+	if len(vgs) != 3 {
+		t.Fatalf("expected 3 VGs, got %d", len(vgs))
+	}
+	if !lvs[0].IsThinPool() || lvs[1].IsThinPool() {
+		t.Error("expected only pool0 and pool1 to be thin pools")
+	}
+	if !lvs[2].IsPartial() || lvs[0].IsPartial() {
+		t.Error("expected only pool1 to be partial")
+	}
+
+	lvs, vgs, err = parseReports([]byte(fullreportOutput))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(lvs) != 1 || len(vgs) != 2 || vgs[1].VGName != "vg2" {
+		t.Errorf("unexpected fullreport LVs %v and VGs %v", lvs, vgs)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	plugin.DataWarning = 80
+	plugin.DataCritical = 90
+	plugin.MetadataWarning = 80
+	plugin.MetadataCritical = 90
+	plugin.VGWarning = 90
+	plugin.VGCritical = 0
+
+	lvs, vgs, err := parseReports([]byte(lvsOutput + vgsOutput))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	criticals, warnings := evaluate(lvs, vgs)
+
+	wantCriticals := []string{
+		"thin pool vg0/pool0 data at 92.50%",
+		"vg1/pool1 is partial",
+		"volume group vg1 has 1 missing PVs",
+		"volume group vg2 has 1 missing PVs",
+	}
+	wantWarnings := []string{
+		"thin pool vg1/pool1 metadata at 85.00%",
+		"volume group vg0 at 95.00% allocated",
+	}
+
+	if len(criticals) != len(wantCriticals) {
+		t.Fatalf("expected criticals %v, got %v", wantCriticals, criticals)
+	}
+	for i := range wantCriticals {
+		if criticals[i] != wantCriticals[i] {
+			t.Errorf("expected critical %q, got %q", wantCriticals[i], criticals[i])
+		}
+	}
+	if len(warnings) != len(wantWarnings) {
+		t.Fatalf("expected warnings %v, got %v", wantWarnings, warnings)
+	}
+	for i := range wantWarnings {
+		if warnings[i] != wantWarnings[i] {
+			t.Errorf("expected warning %q, got %q", wantWarnings[i], warnings[i])
+		}
+	}
+}
+
+func TestParseReports_Invalid(t *testing.T) {
+	if _, _, err := parseReports([]byte("  WARNING: Running as a non-root user")); err == nil {
+		t.Error("expected error for non-JSON output")
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/nmollerup/sensu-check-disk/internal/filter"
+	"github.com/nmollerup/sensu-check-disk/internal/hostroot"
+	"github.com/nmollerup/sensu-check-disk/internal/mountinfo"
+	corev2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// Config represents the check plugin config
+type Config struct {
+	sensu.PluginConfig
+	Types         []string
+	IgnorePaths   []string
+	IncludePaths  []string
+	Probe         string
+	MountTimeout  float64
+	SlowThreshold float64
+	StaleSeverity string
+	HungSeverity  string
+	SlowSeverity  string
+	ErrorSeverity string
+	HostRoot      string
+}
+
+var (
+	plugin = Config{
+		PluginConfig: sensu.PluginConfig{
+			Name:     "check-network-mounts",
+			Short:    "Check that network filesystem mounts respond",
+			Keyspace: "",
+		},
+	}
+
+	options = []sensu.ConfigOption{
+		&sensu.SlicePluginConfigOption[string]{
+			Path:      "Types",
+			Argument:  "types",
+			Shorthand: "t",
+			Default:   []string{"nfs", "nfs4", "cifs", "fuse.sshfs"},
+			Usage:     "Comma-separated list of filesystem types to probe (globs and ~regex supported)",
+			Value:     &plugin.Types,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:      "IgnorePaths",
+			Argument:  "ignore-paths",
+			Shorthand: "i",
+			Usage:     "Comma-separated list of mount paths to ignore (globs and ~regex supported)",
+			Value:     &plugin.IgnorePaths,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:      "IncludePaths",
+			Argument:  "include-paths",
+			Shorthand: "I",
+			Usage:     "Comma-separated list of mount paths to include (if set, only these are probed; globs and ~regex supported)",
+			Value:     &plugin.IncludePaths,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "Probe",
+			Argument: "probe",
+			Default:  probeReaddir,
+			Allow:    probes,
+			Usage:    "How to probe the root of each mount: readdir opens it and reads one entry, stat only reads its attributes, which the client may answer from its cache",
+			Value:    &plugin.Probe,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "MountTimeout",
+			Argument: "mount-timeout",
+			Default:  5,
+			Usage:    "Seconds to wait for a mount to answer before it counts as hung",
+			Value:    &plugin.MountTimeout,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "SlowThreshold",
+			Argument: "slow-threshold",
+			Default:  1,
+			Usage:    "Seconds a mount may take to answer before it counts as slow (0 to disable)",
+			Value:    &plugin.SlowThreshold,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "StaleSeverity",
+			Argument: "stale-severity",
+			Default:  severityCritical,
+			Allow:    severities,
+			Usage:    "Severity of mounts answering with a stale file handle or a disconnected transport: ignore, warning, critical or unknown",
+			Value:    &plugin.StaleSeverity,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "HungSeverity",
+			Argument: "hung-severity",
+			Default:  severityCritical,
+			Allow:    severities,
+			Usage:    "Severity of mounts that do not answer within --mount-timeout: ignore, warning, critical or unknown",
+			Value:    &plugin.HungSeverity,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "SlowSeverity",
+			Argument: "slow-severity",
+			Default:  severityWarning,
+			Allow:    severities,
+			Usage:    "Severity of mounts taking longer than --slow-threshold to answer: ignore, warning, critical or unknown",
+			Value:    &plugin.SlowSeverity,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "ErrorSeverity",
+			Argument: "error-severity",
+			Default:  severityWarning,
+			Allow:    severities,
+			Usage:    "Severity of mounts failing with any other error, such as an I/O error: ignore, warning, critical or unknown",
+			Value:    &plugin.ErrorSeverity,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "HostRoot",
+			Argument: "host-root",
+			Env:      "HOST_ROOT",
+			Usage:    "Directory the host's root filesystem is mounted at when running in a container (e.g. /host)",
+			Value:    &plugin.HostRoot,
+		},
+	}
+)
+
+// Severities accepted by the --*-severity options
+const (
+	severityIgnore   = "ignore"
+	severityWarning  = "warning"
+	severityCritical = "critical"
+	severityUnknown  = "unknown"
+)
+
+var severities = []string{severityIgnore, severityWarning, severityCritical, severityUnknown}
+
+// Probes accepted by --probe
+const (
+	probeReaddir = "readdir"
+	probeStat    = "stat"
+)
+
+var probes = []string{probeReaddir, probeStat}
+
+// Outcomes of a probe, each reported with its own --*-severity
+const (
+	outcomeOK    = "ok"
+	outcomeStale = "stale"
+	outcomeHung  = "hung"
+	outcomeSlow  = "slow"
+	outcomeError = "error"
+)
+
+// errHung is the error of probes that did not return before the deadline
+var errHung = errors.New("no answer")
+
+// probeFuncs probe the root of a mount at path
+var probeFuncs = map[string]func(path string) error{
+	probeReaddir: readdirRoot,
+	probeStat:    statRoot,
+}
+
+// result is the outcome of probing one mount
+type result struct {
+	Mount   mountinfo.Mount
+	Latency time.Duration
+	Err     error
+}
+
+func main() {
+	check := sensu.NewCheck(&plugin.PluginConfig, options, checkArgs, executeCheck, false)
+	check.Execute()
+}
+
+func checkArgs(event *corev2.Event) (int, error) {
+	hostroot.Set(plugin.HostRoot)
+
+	if len(plugin.Types) == 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--types must not be empty")
+	}
+	for _, patterns := range [][]string{plugin.Types, plugin.IgnorePaths, plugin.IncludePaths} {
+		if err := filter.Validate(patterns); err != nil {
+			return sensu.CheckStateWarning, err
+		}
+	}
+	if plugin.MountTimeout <= 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--mount-timeout must be greater than 0")
+	}
+	if plugin.SlowThreshold < 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--slow-threshold must not be negative")
+	}
+	if plugin.SlowThreshold >= plugin.MountTimeout {
+		return sensu.CheckStateWarning, fmt.Errorf("--slow-threshold must be less than --mount-timeout")
+	}
+	return sensu.CheckStateOK, nil
+}
+
+// readdirRoot opens the directory and reads one entry. Opening makes NFS
+// revalidate the directory with the server, and reading needs the server
+// unless the listing is cached.
+func readdirRoot(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	if _, err := dir.Readdirnames(1); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+func statRoot(path string) error {
+	_, err := os.Stat(path)
+	return err
+}
+
+// networkMounts returns the visible mounts whose type is one of types and
+// whose mountpoint passes the path filters
+func networkMounts(mounts []mountinfo.Mount, types, ignore, include []string) []mountinfo.Mount {
+	visible := mountinfo.Visible(mounts)
+
+	var selected []mountinfo.Mount
+	for _, mount := range mounts {
+		if visible[mount.Mountpoint].ID != mount.ID || !filter.MatchAny(types, mount.FSType) {
+			continue
+		}
+		if filter.MatchAny(ignore, mount.Mountpoint) {
+			continue
+		}
+		if len(include) > 0 && !filter.MatchAny(include, mount.Mountpoint) {
+			continue
+		}
+		selected = append(selected, mount)
+	}
+	return selected
+}
+
+// probeAll probes every mount at the same time and waits until timeout has
+// passed since the start at the most. Probes still running then are
+// abandoned and yield errHung, since a call blocked on an unreachable server
+// cannot be interrupted. Results are returned in the order of mounts.
+func probeAll(mounts []mountinfo.Mount, probe func(path string) error, timeout time.Duration) []result {
+	// Buffered so the goroutines can finish and be collected even after we
+	// stopped waiting for them
+	done := make([]chan result, len(mounts))
+	for i, mount := range mounts {
+		done[i] = make(chan result, 1)
+		go func(mount mountinfo.Mount, done chan<- result) {
+			start := time.Now()
+			err := probe(hostroot.Path(mount.Mountpoint))
+			done <- result{Mount: mount, Latency: time.Since(start), Err: err}
+		}(mount, done[i])
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	results := make([]result, len(mounts))
+	expired := false
+	for i, mount := range mounts {
+		if expired {
+			select {
+			case results[i] = <-done[i]:
+			default:
+				results[i] = result{Mount: mount, Latency: timeout, Err: errHung}
+			}
+			continue
+		}
+		select {
+		case results[i] = <-done[i]:
+		case <-deadline.C:
+			expired = true
+			results[i] = result{Mount: mount, Latency: timeout, Err: errHung}
+		}
+	}
+	return results
+}
+
+// classify returns the outcome of a probe. A server refusing access has
+// still answered, so permission errors count as responsive; a FUSE mount
+// whose daemon died answers with ENOTCONN, which is reported as stale like
+// an NFS ESTALE.
+func classify(r result, slow time.Duration) string {
+	switch {
+	case errors.Is(r.Err, errHung):
+		return outcomeHung
+	case errors.Is(r.Err, syscall.ESTALE), errors.Is(r.Err, syscall.ENOTCONN):
+		return outcomeStale
+	case r.Err != nil && !errors.Is(r.Err, os.ErrPermission):
+		return outcomeError
+	case slow > 0 && r.Latency > slow:
+		return outcomeSlow
+	}
+	return outcomeOK
+}
+
+// describe returns the message for a mount with the given outcome
+func describe(r result, outcome string) string {
+	name := fmt.Sprintf("%s (%s, %s)", r.Mount.Mountpoint, r.Mount.Source, r.Mount.FSType)
+	switch outcome {
+	case outcomeStale:
+		return fmt.Sprintf("%s is stale: %v", name, unwrap(r.Err))
+	case outcomeHung:
+		return fmt.Sprintf("%s did not answer within %s", name, r.Latency)
+	case outcomeSlow:
+		return fmt.Sprintf("%s took %s to answer", name, r.Latency.Round(time.Millisecond))
+	case outcomeError:
+		return fmt.Sprintf("%s failed: %v", name, unwrap(r.Err))
+	}
+	return name + " is responsive"
+}
+
+// unwrap drops the operation and path os wraps errors in, which repeat the
+// mountpoint
+func unwrap(err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	return err
+}
+
+func outcomeSeverity(outcome string) string {
+	switch outcome {
+	case outcomeStale:
+		return plugin.StaleSeverity
+	case outcomeHung:
+		return plugin.HungSeverity
+	case outcomeSlow:
+		return plugin.SlowSeverity
+	case outcomeError:
+		return plugin.ErrorSeverity
+	}
+	return severityIgnore
+}
+
+func executeCheck(event *corev2.Event) (int, error) {
+	all, err := mountinfo.Read()
+	if err != nil {
+		return sensu.CheckStateCritical, fmt.Errorf("failed to get mounted partitions: %v", err)
+	}
+	mounts := networkMounts(all, plugin.Types, plugin.IgnorePaths, plugin.IncludePaths)
+	if len(mounts) == 0 {
+		fmt.Println("OK - No network mounts found")
+		return sensu.CheckStateOK, nil
+	}
+
+	timeout := time.Duration(plugin.MountTimeout * float64(time.Second))
+	slow := time.Duration(plugin.SlowThreshold * float64(time.Second))
+	results := probeAll(mounts, probeFuncs[plugin.Probe], timeout)
+
+	var criticals []string
+	var warnings []string
+	var unknowns []string
+
+	for _, r := range results {
+		outcome := classify(r, slow)
+		msg := describe(r, outcome)
+		switch outcomeSeverity(outcome) {
+		case severityCritical:
+			criticals = append(criticals, msg)
+		case severityWarning:
+			warnings = append(warnings, msg)
+		case severityUnknown:
+			unknowns = append(unknowns, msg)
+		}
+	}
+
+	if len(criticals) > 0 {
+		fmt.Printf("CRITICAL - Network mounts not responding normally: %v\n", criticals)
+		return sensu.CheckStateCritical, nil
+	}
+
+	if len(unknowns) > 0 {
+		fmt.Printf("UNKNOWN - Network mounts not responding normally: %v\n", unknowns)
+		return sensu.CheckStateUnknown, nil
+	}
+
+	if len(warnings) > 0 {
+		fmt.Printf("WARNING - Network mounts not responding normally: %v\n", warnings)
+		return sensu.CheckStateWarning, nil
+	}
+
+	fmt.Printf("OK - All %d network mounts are responsive\n", len(results))
+	return sensu.CheckStateOK, nil
+}
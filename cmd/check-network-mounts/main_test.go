@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/nmollerup/sensu-check-disk/internal/mountinfo"
+)
+
+func TestNetworkMounts(t *testing.T) {
+	mounts, err := mountinfo.Parse(strings.NewReader(`22 1 8:1 / / rw - ext4 /dev/sda1 rw
+30 22 0:40 / /mnt/nas rw - nfs4 nas:/export rw
+31 22 0:41 / /mnt/share rw - cifs //files/share rw
+32 22 0:42 / /mnt/backup rw - fuse.sshfs backup@host: rw
+33 30 0:43 / /mnt/nas rw - nfs4 nas:/export2 rw
+34 22 0:44 / /mnt/scratch rw - nfs scratch:/ rw
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := networkMounts(mounts, []string{"nfs", "nfs4", "cifs", "fuse.sshfs"}, []string{"/mnt/scratch"}, nil)
+	var ids []int
+	for _, mount := range got {
+		ids = append(ids, mount.ID)
+	}
+	if fmt.Sprint(ids) != "[31 32 33]" {
+		t.Errorf("networkMounts() = %v, want [31 32 33]", ids)
+	}
+
+	got = networkMounts(mounts, []string{"nfs*"}, nil, []string{"/mnt/s*"})
+	if len(got) != 1 || got[0].ID != 34 {
+		t.Errorf("networkMounts() with include = %+v, want mount 34", got)
+	}
+}
+
+func TestProbeAll(t *testing.T) {
+	mounts := []mountinfo.Mount{
+		{Mountpoint: "/mnt/ok"},
+		{Mountpoint: "/mnt/hung"},
+		{Mountpoint: "/mnt/stale"},
+	}
+	release := make(chan struct{})
+	defer close(release)
+	probe := func(path string) error {
+		switch path {
+		case "/mnt/hung":
+			<-release
+		case "/mnt/stale":
+			return &os.PathError{Op: "open", Path: path, Err: syscall.ESTALE}
+		}
+		return nil
+	}
+
+	start := time.Now()
+	results := probeAll(mounts, probe, 50*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("probeAll() waited %s for a hung mount", elapsed)
+	}
+
+	if results[0].Err != nil || results[0].Mount.Mountpoint != "/mnt/ok" {
+		t.Errorf("unexpected result for /mnt/ok: %+v", results[0])
+	}
+	if !errors.Is(results[1].Err, errHung) {
+		t.Errorf("expected /mnt/hung to time out, got %+v", results[1])
+	}
+	if !errors.Is(results[2].Err, syscall.ESTALE) {
+		t.Errorf("expected a stale handle for /mnt/stale, got %+v", results[2])
+	}
+}
+
+func TestClassify(t *testing.T) {
+	mount := mountinfo.Mount{Mountpoint: "/mnt/nas", Source: "nas:/export", FSType: "nfs4"}
+	tests := []struct {
+		name    string
+		latency time.Duration
+		err     error
+		outcome string
+		message string
+	}{
+		{"responsive", 10 * time.Millisecond, nil, outcomeOK, "/mnt/nas (nas:/export, nfs4) is responsive"},
+		{"permission denied", 10 * time.Millisecond, &os.PathError{Op: "open", Path: "/mnt/nas", Err: syscall.EACCES}, outcomeOK, ""},
+		{"stale", 10 * time.Millisecond, &os.PathError{Op: "open", Path: "/mnt/nas", Err: syscall.ESTALE}, outcomeStale,
+			"/mnt/nas (nas:/export, nfs4) is stale: " + syscall.ESTALE.Error()},
+		{"disconnected fuse", 10 * time.Millisecond, &os.PathError{Op: "open", Path: "/mnt/nas", Err: syscall.ENOTCONN}, outcomeStale, ""},
+		{"hung", 5 * time.Second, errHung, outcomeHung, "/mnt/nas (nas:/export, nfs4) did not answer within 5s"},
+		{"slow", 1500 * time.Millisecond, nil, outcomeSlow, "/mnt/nas (nas:/export, nfs4) took 1.5s to answer"},
+		{"slow error", 1500 * time.Millisecond, &os.PathError{Op: "open", Path: "/mnt/nas", Err: syscall.EIO}, outcomeError,
+			"/mnt/nas (nas:/export, nfs4) failed: " + syscall.EIO.Error()},
+	}
+
+	for _, tt := range tests {
+		r := result{Mount: mount, Latency: tt.latency, Err: tt.err}
+		outcome := classify(r, time.Second)
+		if outcome != tt.outcome {
+			t.Errorf("%s: classify() = %s, want %s", tt.name, outcome, tt.outcome)
+		}
+		if msg := describe(r, outcome); tt.message != "" && msg != tt.message {
+			t.Errorf("%s: describe() = %q, want %q", tt.name, msg, tt.message)
+		}
+	}
+
+	if outcome := classify(result{Mount: mount, Latency: time.Minute}, 0); outcome != outcomeOK {
+		t.Errorf("expected no slow outcome with the threshold disabled, got %s", outcome)
+	}
+}
+
+func TestReaddirRoot(t *testing.T) {
+	if err := readdirRoot(t.TempDir()); err != nil {
+		t.Errorf("readdirRoot() on an empty directory = %v", err)
+	}
+	if err := readdirRoot("/nonexistent/mount"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("readdirRoot() on a missing directory = %v", err)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nmollerup/sensu-check-disk/internal/filter"
+	corev2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// Config represents the check plugin config
+type Config struct {
+	sensu.PluginConfig
+	SoftWarning   float64
+	SoftCritical  float64
+	HardWarning   float64
+	HardCritical  float64
+	GraceWarning  float64
+	GraceCritical float64
+	QuotaTypes    []string
+	IgnoreNames   []string
+	RepquotaPath  string
+	InputFile     string
+}
+
+// Quota is the usage and limits of one user, group or project on one
+// filesystem. Blocks are in KiB; grace times are zero when not running.
+type Quota struct {
+	Type       string
+	Device     string
+	Name       string
+	BlockUsed  uint64
+	BlockSoft  uint64
+	BlockHard  uint64
+	BlockGrace int64
+	FileUsed   uint64
+	FileSoft   uint64
+	FileHard   uint64
+	FileGrace  int64
+}
+
+var (
+	plugin = Config{
+		PluginConfig: sensu.PluginConfig{
+			Name:     "check-quota",
+			Short:    "Check user, group and project filesystem quotas",
+			Keyspace: "",
+		},
+	}
+
+	options = []sensu.ConfigOption{
+		&sensu.PluginConfigOption[float64]{
+			Path:     "SoftWarning",
+			Argument: "soft-warning",
+			Default:  100,
+			Usage:    "Warning threshold percentage of the soft limit (0 to disable)",
+			Value:    &plugin.SoftWarning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "SoftCritical",
+			Argument: "soft-critical",
+			Usage:    "Critical threshold percentage of the soft limit (0 to disable)",
+			Value:    &plugin.SoftCritical,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "HardWarning",
+			Argument: "hard-warning",
+			Default:  90,
+			Usage:    "Warning threshold percentage of the hard limit (0 to disable)",
+			Value:    &plugin.HardWarning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "HardCritical",
+			Argument: "hard-critical",
+			Default:  98,
+			Usage:    "Critical threshold percentage of the hard limit (0 to disable)",
+			Value:    &plugin.HardCritical,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "GraceWarning",
+			Argument: "grace-warning",
+			Default:  24,
+			Usage:    "Warning when a grace period expires within this many hours (0 to disable)",
+			Value:    &plugin.GraceWarning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "GraceCritical",
+			Argument: "grace-critical",
+			Usage:    "Critical when a grace period expires within this many hours (0 to disable); expired grace periods are always critical",
+			Value:    &plugin.GraceCritical,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:      "QuotaTypes",
+			Argument:  "quota-types",
+			Shorthand: "t",
+			Default:   []string{"user", "group", "project"},
+			Usage:     "Comma-separated list of quota types to check: user, group, project",
+			Value:     &plugin.QuotaTypes,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:      "IgnoreNames",
+			Argument:  "ignore-names",
+			Shorthand: "i",
+			Usage:     "Comma-separated list of user, group or project names to ignore (globs and ~regex supported)",
+			Value:     &plugin.IgnoreNames,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:      "RepquotaPath",
+			Argument:  "repquota-path",
+			Shorthand: "r",
+			Default:   "repquota",
+			Usage:     "Path to repquota binary",
+			Value:     &plugin.RepquotaPath,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:      "InputFile",
+			Argument:  "input-file",
+			Shorthand: "f",
+			Usage:     "Read recorded repquota --raw-grace output from this file instead of running repquota",
+			Value:     &plugin.InputFile,
+		},
+	}
+
+	quotaTypeFlags = map[string]string{
+		"user":    "-u",
+		"group":   "-g",
+		"project": "-P",
+	}
+
+	reportHeader = regexp.MustCompile(`^\*\*\* Report for (\w+) quotas on device (\S+)`)
+)
+
+func main() {
+	check := sensu.NewCheck(&plugin.PluginConfig, options, checkArgs, executeCheck, false)
+	check.Execute()
+}
+
+func checkArgs(event *corev2.Event) (int, error) {
+	if len(plugin.QuotaTypes) == 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--quota-types must not be empty")
+	}
+	for _, quotaType := range plugin.QuotaTypes {
+		if _, ok := quotaTypeFlags[quotaType]; !ok {
+			return sensu.CheckStateWarning, fmt.Errorf("unknown quota type %q", quotaType)
+		}
+	}
+	if plugin.SoftWarning > 0 && plugin.SoftCritical > 0 && plugin.SoftWarning >= plugin.SoftCritical {
+		return sensu.CheckStateWarning, fmt.Errorf("--soft-warning must be less than --soft-critical")
+	}
+	if plugin.HardWarning > 0 && plugin.HardCritical > 0 && plugin.HardWarning >= plugin.HardCritical {
+		return sensu.CheckStateWarning, fmt.Errorf("--hard-warning must be less than --hard-critical")
+	}
+	if plugin.GraceWarning > 0 && plugin.GraceCritical > 0 && plugin.GraceWarning <= plugin.GraceCritical {
+		return sensu.CheckStateWarning, fmt.Errorf("--grace-warning must be greater than --grace-critical")
+	}
+	if err := filter.Validate(plugin.IgnoreNames); err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	return sensu.CheckStateOK, nil
+}
+
+func executeCheck(event *corev2.Event) (int, error) {
+	var output string
+	if plugin.InputFile != "" {
+		data, err := os.ReadFile(plugin.InputFile)
+		if err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("failed to read input file: %v", err)
+		}
+		output = string(data)
+	} else {
+		args := []string{"-a", "--raw-grace"}
+		for _, quotaType := range plugin.QuotaTypes {
+			args = append(args, quotaTypeFlags[quotaType])
+		}
+		data, err := exec.Command(plugin.RepquotaPath, args...).Output()
+		if err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("failed to run repquota: %v", err)
+		}
+		output = string(data)
+	}
+
+	quotas, err := parseRepquota(output)
+	if err != nil {
+		return sensu.CheckStateCritical, fmt.Errorf("failed to parse repquota output: %v", err)
+	}
+
+	criticals, warnings := evaluate(quotas, time.Now())
+
+	if len(criticals) > 0 {
+		fmt.Printf("CRITICAL - Quota critical on: %v\n", criticals)
+		return sensu.CheckStateCritical, nil
+	}
+
+	if len(warnings) > 0 {
+		fmt.Printf("WARNING - Quota warning on: %v\n", warnings)
+		return sensu.CheckStateWarning, nil
+	}
+
+	fmt.Println("OK - All quotas within thresholds")
+	return sensu.CheckStateOK, nil
+}
+
+// parseRepquota parses the output of `repquota -a --raw-grace`, where each
+// quota line has the fields name, flags, block used, soft, hard, grace, file
+// used, soft, hard, grace
+func parseRepquota(output string) ([]Quota, error) {
+	var quotas []Quota
+	var quotaType, device string
+	inTable := false
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if matches := reportHeader.FindStringSubmatch(line); matches != nil {
+			quotaType, device = matches[1], matches[2]
+			inTable = false
+			continue
+		}
+		if strings.HasPrefix(line, "---") {
+			inTable = true
+			continue
+		}
+		if line == "" {
+			inTable = false
+			continue
+		}
+		if !inTable {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 10 {
+			return nil, fmt.Errorf("unexpected quota line %q (is --raw-grace supported?)", line)
+		}
+
+		values := make([]int64, 8)
+		for i, field := range fields[2:] {
+			value, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected value %q in quota line %q", field, line)
+			}
+			values[i] = value
+		}
+
+		quotas = append(quotas, Quota{
+			Type:       quotaType,
+			Device:     device,
+			Name:       fields[0],
+			BlockUsed:  uint64(values[0]),
+			BlockSoft:  uint64(values[1]),
+			BlockHard:  uint64(values[2]),
+			BlockGrace: values[3],
+			FileUsed:   uint64(values[4]),
+			FileSoft:   uint64(values[5]),
+			FileHard:   uint64(values[6]),
+			FileGrace:  values[7],
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return quotas, nil
+}
+
+// evaluate compares block and file usage against the soft and hard limits,
+// and running grace periods against the grace thresholds
+func evaluate(quotas []Quota, now time.Time) (criticals []string, warnings []string) {
+	for _, q := range quotas {
+		if filter.MatchAny(plugin.IgnoreNames, q.Name) {
+			continue
+		}
+
+		owner := fmt.Sprintf("%s %s on %s", q.Type, q.Name, q.Device)
+		resources := []struct {
+			name             string
+			used, soft, hard uint64
+			grace            int64
+		}{
+			{"block", q.BlockUsed, q.BlockSoft, q.BlockHard, q.BlockGrace},
+			{"file", q.FileUsed, q.FileSoft, q.FileHard, q.FileGrace},
+		}
+
+		for _, r := range resources {
+			// Both limits are evaluated, as the soft limit thresholds may be
+			// more severe than those of the hard limit
+			for _, limit := range []struct {
+				name              string
+				value             uint64
+				warning, critical float64
+			}{
+				{"hard", r.hard, plugin.HardWarning, plugin.HardCritical},
+				{"soft", r.soft, plugin.SoftWarning, plugin.SoftCritical},
+			} {
+				crit, warn := evaluateLimit(fmt.Sprintf("%s %s %s limit", owner, r.name, limit.name), r.used, limit.value, limit.warning, limit.critical)
+				criticals = append(criticals, crit...)
+				warnings = append(warnings, warn...)
+			}
+
+			if r.grace <= 0 {
+				continue
+			}
+			remaining := time.Unix(r.grace, 0).Sub(now)
+			if remaining <= 0 {
+				criticals = append(criticals, fmt.Sprintf("%s %s grace period expired", owner, r.name))
+				continue
+			}
+			msg := fmt.Sprintf("%s %s grace period expires in %s", owner, r.name, remaining.Round(time.Minute))
+			if plugin.GraceCritical > 0 && remaining.Hours() < plugin.GraceCritical {
+				criticals = append(criticals, msg)
+			} else if plugin.GraceWarning > 0 && remaining.Hours() < plugin.GraceWarning {
+				warnings = append(warnings, msg)
+			}
+		}
+	}
+
+	return criticals, warnings
+}
+
+func evaluateLimit(name string, used, limit uint64, warning, critical float64) (criticals []string, warnings []string) {
+	if limit == 0 {
+		return nil, nil
+	}
+
+	percent := float64(used) / float64(limit) * 100.0
+	msg := fmt.Sprintf("%s at %.2f%%", name, percent)
+	if critical > 0 && percent >= critical {
+		criticals = append(criticals, msg)
+	} else if warning > 0 && percent >= warning {
+		warnings = append(warnings, msg)
+	}
+	return criticals, warnings
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const repquotaOutput = `*** Report for user quotas on device /dev/sdb1
+Block grace time: 7days; Inode grace time: 7days
+                        Block limits                File limits
+User            used    soft    hard  grace    used  soft  hard  grace
+----------------------------------------------------------------------
+root      --      20       0       0      0       2     0     0      0
+alice     +-  110000  100000  200000 1700086400     10     0     0      0
+bob       --  195000  150000  200000      0     500   900  1000      0
+
+*** Report for project quotas on device /dev/sdc1
+Block grace time: 7days; Inode grace time: 7days
+                        Block limits                File limits
+Project         used    soft    hard  grace    used  soft  hard  grace
+----------------------------------------------------------------------
+#1001     -+    1000       0       0      0    1200  1000  2000 1699990000
+`
+
+func TestParseRepquota(t *testing.T) {
+	quotas, err := parseRepquota(repquotaOutput)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(quotas) != 4 {
+		t.Fatalf("expected 4 quotas, got %d", len(quotas))
+	}
+
+	alice := quotas[1]
+	if alice.Type != "user" || alice.Device != "/dev/sdb1" || alice.Name != "alice" ||
+		alice.BlockUsed != 110000 || alice.BlockSoft != 100000 || alice.BlockHard != 200000 || alice.BlockGrace != 1700086400 {
+		t.Errorf("unexpected quota for alice: %+v", alice)
+	}
+
+	project := quotas[3]
+	if project.Type != "project" || project.Device != "/dev/sdc1" || project.Name != "#1001" || project.FileGrace != 1699990000 {
+		t.Errorf("unexpected project quota: %+v", project)
+	}
+}
+
+func TestParseRepquota_WithoutRawGrace(t *testing.T) {
+	output := `*** Report for user quotas on device /dev/sdb1
+----------------------------------------------------------------------
+alice     +-  110000  100000  200000  6days      10     0     0
+`
+	if _, err := parseRepquota(output); err == nil {
+		t.Error("expected error for output without --raw-grace")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	plugin.SoftWarning = 100
+	plugin.SoftCritical = 0
+	plugin.HardWarning = 90
+	plugin.HardCritical = 98
+	plugin.GraceWarning = 48
+	plugin.GraceCritical = 0
+	plugin.IgnoreNames = []string{"root"}
+
+	quotas, err := parseRepquota(repquotaOutput)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	criticals, warnings := evaluate(quotas, now)
+
+	wantCriticals := []string{
+		"project #1001 on /dev/sdc1 file grace period expired",
+	}
+	wantWarnings := []string{
+		"user alice on /dev/sdb1 block soft limit at 110.00%",
+		"user alice on /dev/sdb1 block grace period expires in 24h0m0s",
+		"user bob on /dev/sdb1 block hard limit at 97.50%",
+		"user bob on /dev/sdb1 block soft limit at 130.00%",
+		"project #1001 on /dev/sdc1 file soft limit at 120.00%",
+	}
+
+	if len(criticals) != len(wantCriticals) {
+		t.Fatalf("expected criticals %v, got %v", wantCriticals, criticals)
+	}
+	for i := range wantCriticals {
+		if criticals[i] != wantCriticals[i] {
+			t.Errorf("expected critical %q, got %q", wantCriticals[i], criticals[i])
+		}
+	}
+	if len(warnings) != len(wantWarnings) {
+		t.Fatalf("expected warnings %v, got %v", wantWarnings, warnings)
+	}
+	for i := range wantWarnings {
+		if warnings[i] != wantWarnings[i] {
+			t.Errorf("expected warning %q, got %q", wantWarnings[i], warnings[i])
+		}
+	}
+}
+
+func TestEvaluate_HardWarningSoftCritical(t *testing.T) {
+	plugin.SoftWarning = 100
+	plugin.SoftCritical = 120
+	plugin.HardWarning = 90
+	plugin.HardCritical = 98
+	plugin.GraceWarning = 0
+	plugin.GraceCritical = 0
+	plugin.IgnoreNames = nil
+
+	quotas := []Quota{{Type: "user", Device: "/dev/sdb1", Name: "carol", BlockUsed: 182000, BlockSoft: 140000, BlockHard: 200000}}
+	criticals, warnings := evaluate(quotas, time.Unix(1700000000, 0))
+
+	if len(criticals) != 1 || criticals[0] != "user carol on /dev/sdb1 block soft limit at 130.00%" {
+		t.Errorf("expected the soft limit critical, got %v", criticals)
+	}
+	if len(warnings) != 1 || warnings[0] != "user carol on /dev/sdb1 block hard limit at 91.00%" {
+		t.Errorf("expected the hard limit warning, got %v", warnings)
+	}
+}
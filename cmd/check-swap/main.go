@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nmollerup/sensu-check-disk/internal/bytesize"
+	"github.com/nmollerup/sensu-check-disk/internal/hostroot"
+	"github.com/nmollerup/sensu-check-disk/internal/statefile"
+	"github.com/nmollerup/sensu-check-disk/internal/swaps"
+	corev2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// Config represents the check plugin config
+type Config struct {
+	sensu.PluginConfig
+	Warning         float64
+	Critical        float64
+	SwapInWarning   float64
+	SwapInCritical  float64
+	SwapOutWarning  float64
+	SwapOutCritical float64
+	StateFile       string
+	HostRoot        string
+}
+
+// Counters are the swap counters of /proc/vmstat, in pages, at a point in
+// time. They are stored in the state file between runs.
+type Counters struct {
+	Time    int64  `json:"time"`
+	SwapIn  uint64 `json:"pswpin"`
+	SwapOut uint64 `json:"pswpout"`
+}
+
+var (
+	plugin = Config{
+		PluginConfig: sensu.PluginConfig{
+			Name:     "check-swap",
+			Short:    "Check swap usage and swap activity",
+			Keyspace: "",
+		},
+	}
+
+	options = []sensu.ConfigOption{
+		&sensu.PluginConfigOption[float64]{
+			Path:      "Warning",
+			Argument:  "warning",
+			Shorthand: "w",
+			Default:   50,
+			Usage:     "Warning threshold percentage of swap space used (0 to disable)",
+			Value:     &plugin.Warning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:      "Critical",
+			Argument:  "critical",
+			Shorthand: "c",
+			Default:   80,
+			Usage:     "Critical threshold percentage of swap space used (0 to disable)",
+			Value:     &plugin.Critical,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "SwapInWarning",
+			Argument: "swap-in-warning",
+			Default:  100,
+			Usage:    "Warning threshold for pages swapped in per second (0 to disable)",
+			Value:    &plugin.SwapInWarning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "SwapInCritical",
+			Argument: "swap-in-critical",
+			Usage:    "Critical threshold for pages swapped in per second (0 to disable)",
+			Value:    &plugin.SwapInCritical,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "SwapOutWarning",
+			Argument: "swap-out-warning",
+			Default:  100,
+			Usage:    "Warning threshold for pages swapped out per second (0 to disable)",
+			Value:    &plugin.SwapOutWarning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "SwapOutCritical",
+			Argument: "swap-out-critical",
+			Usage:    "Critical threshold for pages swapped out per second (0 to disable)",
+			Value:    &plugin.SwapOutCritical,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "StateFile",
+			Argument: "state-file",
+			Usage:    "Path to the file storing the swap counters of the previous run, from which the swap rates are computed (default: a file in the user cache directory named after --host-root)",
+			Value:    &plugin.StateFile,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "HostRoot",
+			Argument: "host-root",
+			Env:      "HOST_ROOT",
+			Usage:    "Directory the host's root filesystem is mounted at when running in a container (e.g. /host)",
+			Value:    &plugin.HostRoot,
+		},
+	}
+)
+
+func main() {
+	check := sensu.NewCheck(&plugin.PluginConfig, options, checkArgs, executeCheck, false)
+	check.Execute()
+}
+
+func checkArgs(event *corev2.Event) (int, error) {
+	hostroot.Set(plugin.HostRoot)
+
+	if plugin.Warning > 0 && plugin.Critical > 0 && plugin.Warning >= plugin.Critical {
+		return sensu.CheckStateWarning, fmt.Errorf("--warning must be less than --critical")
+	}
+	if plugin.SwapInWarning > 0 && plugin.SwapInCritical > 0 && plugin.SwapInWarning >= plugin.SwapInCritical {
+		return sensu.CheckStateWarning, fmt.Errorf("--swap-in-warning must be less than --swap-in-critical")
+	}
+	if plugin.SwapOutWarning > 0 && plugin.SwapOutCritical > 0 && plugin.SwapOutWarning >= plugin.SwapOutCritical {
+		return sensu.CheckStateWarning, fmt.Errorf("--swap-out-warning must be less than --swap-out-critical")
+	}
+	if plugin.StateFile == "" {
+		path, err := statefile.DefaultPath("check-swap", plugin.HostRoot)
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("no default state file, set --state-file: %v", err)
+		}
+		plugin.StateFile = path
+	}
+	return sensu.CheckStateOK, nil
+}
+
+// readCounters returns the pswpin and pswpout counters of the vmstat file at
+// path
+func readCounters(path string, now time.Time) (Counters, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Counters{}, err
+	}
+	defer file.Close()
+
+	counters := Counters{Time: now.Unix()}
+	found := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || (name != "pswpin" && name != "pswpout") {
+			continue
+		}
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return Counters{}, fmt.Errorf("invalid %s value %q", name, value)
+		}
+		if name == "pswpin" {
+			counters.SwapIn = n
+		} else {
+			counters.SwapOut = n
+		}
+		found++
+	}
+	if err := scanner.Err(); err != nil {
+		return Counters{}, err
+	}
+	if found < 2 {
+		return Counters{}, fmt.Errorf("pswpin or pswpout missing from %s", path)
+	}
+	return counters, nil
+}
+
+func loadCounters(path string) (Counters, bool) {
+	var counters Counters
+	if !statefile.Load(path, &counters) {
+		return Counters{}, false
+	}
+	return counters, true
+}
+
+// rates returns the pages swapped in and out per second between two
+// samples. It reports false when they cannot be compared because no time
+// passed or the counters were reset by a reboot.
+func rates(previous, current Counters) (in, out float64, ok bool) {
+	elapsed := float64(current.Time - previous.Time)
+	if elapsed <= 0 || current.SwapIn < previous.SwapIn || current.SwapOut < previous.SwapOut {
+		return 0, 0, false
+	}
+	in = float64(current.SwapIn-previous.SwapIn) / elapsed
+	out = float64(current.SwapOut-previous.SwapOut) / elapsed
+	return in, out, true
+}
+
+// evaluateUsage checks the share of swap space used across all swap areas
+func evaluateUsage(areas []swaps.Swap) (criticals, warnings []string, summary string) {
+	var size, used uint64
+	for _, area := range areas {
+		size += area.Size
+		used += area.Used
+	}
+	if size == 0 {
+		return nil, nil, "no swap active"
+	}
+
+	usedPercent := float64(used) / float64(size) * 100.0
+	msg := fmt.Sprintf("swap at %.2f%% used of %s", usedPercent, bytesize.Format(size*1024))
+	if plugin.Critical > 0 && usedPercent >= plugin.Critical {
+		criticals = append(criticals, msg)
+	} else if plugin.Warning > 0 && usedPercent >= plugin.Warning {
+		warnings = append(warnings, msg)
+	}
+	return criticals, warnings, msg
+}
+
+// evaluateRate checks a swap rate in pages per second against its thresholds
+func evaluateRate(direction string, rate, warning, critical float64) (criticals, warnings []string, summary string) {
+	msg := fmt.Sprintf("%.1f pages/s swapped %s", rate, direction)
+	if critical > 0 && rate >= critical {
+		criticals = append(criticals, msg)
+	} else if warning > 0 && rate >= warning {
+		warnings = append(warnings, msg)
+	}
+	return criticals, warnings, msg
+}
+
+func executeCheck(event *corev2.Event) (int, error) {
+	areas, err := swaps.Read()
+	if err != nil {
+		return sensu.CheckStateCritical, fmt.Errorf("failed to read active swap areas: %v", err)
+	}
+	current, err := readCounters(hostroot.Proc("vmstat"), time.Now())
+	if err != nil {
+		return sensu.CheckStateCritical, fmt.Errorf("failed to read vmstat: %v", err)
+	}
+
+	criticals, warnings, usage := evaluateUsage(areas)
+	summary := []string{usage}
+
+	previous, ok := loadCounters(plugin.StateFile)
+	if err := statefile.Save(plugin.StateFile, current); err != nil {
+		return sensu.CheckStateCritical, fmt.Errorf("failed to save state file: %v", err)
+	}
+	if ok {
+		if in, out, ok := rates(previous, current); ok {
+			crit, warn, msg := evaluateRate("in", in, plugin.SwapInWarning, plugin.SwapInCritical)
+			criticals, warnings = append(criticals, crit...), append(warnings, warn...)
+			summary = append(summary, msg)
+
+			crit, warn, msg = evaluateRate("out", out, plugin.SwapOutWarning, plugin.SwapOutCritical)
+			criticals, warnings = append(criticals, crit...), append(warnings, warn...)
+			summary = append(summary, msg)
+		}
+	}
+
+	if len(criticals) > 0 {
+		fmt.Printf("CRITICAL - Swap critical: %v\n", criticals)
+		return sensu.CheckStateCritical, nil
+	}
+
+	if len(warnings) > 0 {
+		fmt.Printf("WARNING - Swap warning: %v\n", warnings)
+		return sensu.CheckStateWarning, nil
+	}
+
+	output := strings.Join(summary, ", ")
+	fmt.Printf("OK - %s\n", strings.ToUpper(output[:1])+output[1:])
+	return sensu.CheckStateOK, nil
+}
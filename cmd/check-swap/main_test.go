@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nmollerup/sensu-check-disk/internal/statefile"
+	"github.com/nmollerup/sensu-check-disk/internal/swaps"
+)
+
+func TestReadCounters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vmstat")
+	data := "nr_free_pages 12345\npswpin 1500\npswpout 4200\npgmajfault 17\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	counters, err := readCounters(path, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Counters{Time: 1700000000, SwapIn: 1500, SwapOut: 4200}
+	if counters != want {
+		t.Errorf("readCounters() = %+v, want %+v", counters, want)
+	}
+
+	if err := os.WriteFile(path, []byte("nr_free_pages 12345\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCounters(path, now); err == nil {
+		t.Error("expected error for vmstat without swap counters")
+	}
+}
+
+func TestCountersState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if _, ok := loadCounters(path); ok {
+		t.Error("expected no counters before the first run")
+	}
+
+	want := Counters{Time: 1700000000, SwapIn: 1, SwapOut: 2}
+	if err := statefile.Save(path, want); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := loadCounters(path); !ok || got != want {
+		t.Errorf("loadCounters() = %+v, %v, want %+v", got, ok, want)
+	}
+}
+
+func TestRates(t *testing.T) {
+	previous := Counters{Time: 1000, SwapIn: 100, SwapOut: 1000}
+
+	in, out, ok := rates(previous, Counters{Time: 1060, SwapIn: 160, SwapOut: 7000})
+	if !ok || in != 1 || out != 100 {
+		t.Errorf("rates() = %v, %v, %v, want 1, 100, true", in, out, ok)
+	}
+	if _, _, ok := rates(previous, Counters{Time: 1060, SwapIn: 5, SwapOut: 10}); ok {
+		t.Error("expected counters reset by a reboot to be skipped")
+	}
+	if _, _, ok := rates(previous, previous); ok {
+		t.Error("expected samples taken at the same time to be skipped")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	plugin.Warning, plugin.Critical = 50, 80
+
+	areas := []swaps.Swap{
+		{Filename: "/dev/sda2", Size: 1048576, Used: 524288},
+		{Filename: "/swapfile", Size: 1048576, Used: 524288},
+	}
+	criticals, warnings, _ := evaluateUsage(areas)
+	if len(criticals) != 0 || len(warnings) != 1 || warnings[0] != "swap at 50.00% used of 2.00 GiB" {
+		t.Errorf("evaluateUsage() = %v, %v", criticals, warnings)
+	}
+
+	areas[1].Used = 1048576
+	if criticals, _, _ := evaluateUsage(areas); len(criticals) != 0 {
+		t.Errorf("expected 75%% used to stay below critical, got %v", criticals)
+	}
+
+	if criticals, warnings, summary := evaluateUsage(nil); len(criticals)+len(warnings) != 0 || summary != "no swap active" {
+		t.Errorf("evaluateUsage(nil) = %v, %v, %q", criticals, warnings, summary)
+	}
+
+	criticals, warnings, _ = evaluateRate("out", 150, 100, 0)
+	if len(criticals) != 0 || len(warnings) != 1 || warnings[0] != "150.0 pages/s swapped out" {
+		t.Errorf("evaluateRate() = %v, %v", criticals, warnings)
+	}
+	if criticals, _, _ := evaluateRate("in", 600, 100, 500); len(criticals) != 1 {
+		t.Errorf("expected 600 pages/s to be critical, got %v", criticals)
+	}
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/nmollerup/sensu-check-disk/internal/bytesize"
+	"github.com/nmollerup/sensu-check-disk/internal/capacity"
+	"github.com/nmollerup/sensu-check-disk/internal/filter"
+	corev2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// Config represents the check plugin config
+type Config struct {
+	sensu.PluginConfig
+	Warning               float64
+	Critical              float64
+	WarningFree           string
+	CriticalFree          string
+	Magic                 float64
+	Normal                float64
+	Minimum               float64
+	RulesFile             string
+	FragmentationWarning  float64
+	FragmentationCritical float64
+	IgnorePools           []string
+	IncludePools          []string
+	IgnoreDatasets        []string
+	IncludeDatasets       []string
+	SkipDatasets          bool
+	ZpoolPath             string
+	ZfsPath               string
+
+	warningFree  uint64
+	criticalFree uint64
+}
+
+// Pool is a line of `zpool list -Hp`
+type Pool struct {
+	Name          string
+	Size          uint64
+	Alloc         uint64
+	Free          uint64
+	Fragmentation float64 // -1 when not reported
+	Capacity      float64
+	Health        string
+}
+
+// Dataset is a line of
+// `zfs list -Hp -o name,used,avail,quota,refquota,reservation,refreservation`
+type Dataset struct {
+	Name           string
+	Used           uint64
+	Avail          uint64
+	Quota          uint64
+	RefQuota       uint64
+	Reservation    uint64
+	RefReservation uint64
+}
+
+// UsedPercent is the share of the space available to the dataset that is in
+// use. ZFS already limits avail by quotas, refquotas and the reservations of
+// other datasets, adds the unused part of the dataset's own reservation to
+// avail and counts a refreservation as used, so this is relative to whichever
+// limit is closest.
+func (d Dataset) UsedPercent() float64 {
+	if d.Used+d.Avail == 0 {
+		return 0
+	}
+	return float64(d.Used) / float64(d.Used+d.Avail) * 100.0
+}
+
+var (
+	plugin = Config{
+		PluginConfig: sensu.PluginConfig{
+			Name:     "check-zfs-capacity",
+			Short:    "Check ZFS pool and dataset capacity",
+			Keyspace: "",
+		},
+	}
+
+	options = []sensu.ConfigOption{
+		&sensu.PluginConfigOption[float64]{
+			Path:      "Warning",
+			Argument:  "warning",
+			Shorthand: "w",
+			Usage:     "Warning threshold percentage for pool and dataset usage",
+			Value:     &plugin.Warning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:      "Critical",
+			Argument:  "critical",
+			Shorthand: "c",
+			Usage:     "Critical threshold percentage for pool and dataset usage",
+			Value:     &plugin.Critical,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "WarningFree",
+			Argument: "warning-free",
+			Usage:    "Warning when free space of a pool or dataset is below this size (e.g. 10GiB, 500MB)",
+			Value:    &plugin.WarningFree,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "CriticalFree",
+			Argument: "critical-free",
+			Usage:    "Critical when free space of a pool or dataset is below this size (e.g. 5GiB, 200MB)",
+			Value:    &plugin.CriticalFree,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:      "Magic",
+			Argument:  "magic",
+			Shorthand: "m",
+			Default:   1.0,
+			Usage:     "Magic factor to raise percentage thresholds for pools and datasets larger than --normal, and lower them for smaller ones of at least --minimum GiB (1.0 disables adjustment)",
+			Value:     &plugin.Magic,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:      "Normal",
+			Argument:  "normal",
+			Shorthand: "n",
+			Default:   20,
+			Usage:     "Size in GiB for which percentage thresholds are not adjusted by --magic",
+			Value:     &plugin.Normal,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:      "Minimum",
+			Argument:  "minimum",
+			Shorthand: "l",
+			Default:   100,
+			Usage:     "Minimum size in GiB for --magic adjustment to apply",
+			Value:     &plugin.Minimum,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:      "RulesFile",
+			Argument:  "rules-file",
+			Shorthand: "r",
+			Usage:     "Path to JSON or YAML (.yaml, .yml) file with per-dataset threshold rules, in the format of check-disk-usage",
+			Value:     &plugin.RulesFile,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "FragmentationWarning",
+			Argument: "fragmentation-warning",
+			Usage:    "Warning threshold percentage for pool fragmentation (0 to disable)",
+			Value:    &plugin.FragmentationWarning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "FragmentationCritical",
+			Argument: "fragmentation-critical",
+			Usage:    "Critical threshold percentage for pool fragmentation (0 to disable)",
+			Value:    &plugin.FragmentationCritical,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:      "IgnorePools",
+			Argument:  "ignore-pools",
+			Shorthand: "p",
+			Usage:     "Comma-separated list of pools to ignore (globs and ~regex supported)",
+			Value:     &plugin.IgnorePools,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:      "IncludePools",
+			Argument:  "include-pools",
+			Shorthand: "P",
+			Usage:     "Comma-separated list of pools to include (if set, only these and their datasets are checked; globs and ~regex supported)",
+			Value:     &plugin.IncludePools,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:      "IgnoreDatasets",
+			Argument:  "ignore-datasets",
+			Shorthand: "i",
+			Usage:     "Comma-separated list of datasets to ignore (globs and ~regex supported)",
+			Value:     &plugin.IgnoreDatasets,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:      "IncludeDatasets",
+			Argument:  "include-datasets",
+			Shorthand: "I",
+			Usage:     "Comma-separated list of datasets to include (if set, only these are checked; globs and ~regex supported)",
+			Value:     &plugin.IncludeDatasets,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "SkipDatasets",
+			Argument: "skip-datasets",
+			Usage:    "Only check pools, not the datasets and volumes in them",
+			Value:    &plugin.SkipDatasets,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "ZpoolPath",
+			Argument: "zpool-path",
+			Default:  "zpool",
+			Usage:    "Path to zpool binary",
+			Value:    &plugin.ZpoolPath,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "ZfsPath",
+			Argument: "zfs-path",
+			Default:  "zfs",
+			Usage:    "Path to zfs binary",
+			Value:    &plugin.ZfsPath,
+		},
+	}
+
+	rules []capacity.Rule
+)
+
+func main() {
+	check := sensu.NewCheck(&plugin.PluginConfig, options, checkArgs, executeCheck, false)
+	check.Execute()
+}
+
+func checkArgs(event *corev2.Event) (int, error) {
+	if plugin.Critical <= 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--critical is required and must be greater than 0")
+	}
+	if plugin.Warning <= 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--warning is required and must be greater than 0")
+	}
+	if plugin.Warning >= plugin.Critical {
+		return sensu.CheckStateWarning, fmt.Errorf("--warning must be less than --critical")
+	}
+	if plugin.Magic <= 0 || plugin.Magic > 1 {
+		return sensu.CheckStateWarning, fmt.Errorf("--magic must be greater than 0 and at most 1")
+	}
+	if plugin.Normal <= 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--normal must be greater than 0")
+	}
+	if plugin.WarningFree != "" {
+		size, err := bytesize.Parse(plugin.WarningFree)
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("invalid --warning-free: %v", err)
+		}
+		plugin.warningFree = size
+	}
+	if plugin.CriticalFree != "" {
+		size, err := bytesize.Parse(plugin.CriticalFree)
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("invalid --critical-free: %v", err)
+		}
+		plugin.criticalFree = size
+	}
+	if plugin.warningFree > 0 && plugin.criticalFree > 0 && plugin.warningFree <= plugin.criticalFree {
+		return sensu.CheckStateWarning, fmt.Errorf("--warning-free must be greater than --critical-free")
+	}
+	if plugin.FragmentationWarning < 0 || plugin.FragmentationCritical < 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--fragmentation-warning and --fragmentation-critical must not be negative")
+	}
+	if plugin.FragmentationWarning > 0 && plugin.FragmentationCritical > 0 && plugin.FragmentationWarning >= plugin.FragmentationCritical {
+		return sensu.CheckStateWarning, fmt.Errorf("--fragmentation-warning must be less than --fragmentation-critical")
+	}
+	if err := filter.Validate(plugin.IgnorePools, plugin.IncludePools, plugin.IgnoreDatasets, plugin.IncludeDatasets); err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	if plugin.RulesFile != "" {
+		var err error
+		rules, err = capacity.LoadRules(plugin.RulesFile, defaultThresholds())
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("failed to load rules file: %v", err)
+		}
+	}
+	return sensu.CheckStateOK, nil
+}
+
+func executeCheck(event *corev2.Event) (int, error) {
+	output, err := exec.Command(plugin.ZpoolPath, "list", "-Hp", "-o", "name,size,alloc,free,frag,cap,health").Output()
+	if err != nil {
+		return sensu.CheckStateCritical, fmt.Errorf("failed to run zpool list: %v", err)
+	}
+	pools, err := parseZpoolList(string(output))
+	if err != nil {
+		return sensu.CheckStateCritical, fmt.Errorf("failed to parse zpool list output: %v", err)
+	}
+
+	var datasets []Dataset
+	if !plugin.SkipDatasets {
+		output, err := exec.Command(plugin.ZfsPath, "list", "-Hp", "-o", "name,used,avail,quota,refquota,reservation,refreservation").Output()
+		if err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("failed to run zfs list: %v", err)
+		}
+		datasets, err = parseZfsList(string(output))
+		if err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("failed to parse zfs list output: %v", err)
+		}
+	}
+
+	criticals, warnings, fragmentation := evaluate(pools, datasets)
+
+	if len(criticals) > 0 {
+		fmt.Printf("CRITICAL - ZFS capacity exceeded critical threshold on: %v%s\n", criticals, fragmentation)
+		return sensu.CheckStateCritical, nil
+	}
+
+	if len(warnings) > 0 {
+		fmt.Printf("WARNING - ZFS capacity exceeded warning threshold on: %v%s\n", warnings, fragmentation)
+		return sensu.CheckStateWarning, nil
+	}
+
+	fmt.Printf("OK - All ZFS pools and datasets within thresholds%s\n", fragmentation)
+	return sensu.CheckStateOK, nil
+}
+
+// evaluate reports pools that are not ONLINE, and compares pools and datasets
+// against the thresholds of the first matching rule, or the command-line
+// thresholds. It also returns a summary of
+// pool fragmentation to append to the output.
+func evaluate(pools []Pool, datasets []Dataset) (criticals []string, warnings []string, fragmentation string) {
+	var fragmented []string
+	checked := make(map[string]bool)
+
+	for _, pool := range pools {
+		if skip(pool.Name, plugin.IgnorePools, plugin.IncludePools) {
+			continue
+		}
+		checked[pool.Name] = true
+
+		// A degraded pool still serves data from its redundancy; any other
+		// state but ONLINE means data is or may become inaccessible
+		switch pool.Health {
+		case "ONLINE":
+		case "DEGRADED":
+			warnings = append(warnings, fmt.Sprintf("pool %s is %s", pool.Name, pool.Health))
+		default:
+			criticals = append(criticals, fmt.Sprintf("pool %s is %s", pool.Name, pool.Health))
+		}
+
+		usage := &disk.UsageStat{
+			Total:       pool.Size,
+			Used:        pool.Alloc,
+			Free:        pool.Free,
+			UsedPercent: pool.Capacity,
+		}
+		crit, warn := capacity.Evaluate("pool "+pool.Name, usage, thresholdsFor(pool.Name), scaling())
+		criticals = append(criticals, crit...)
+		warnings = append(warnings, warn...)
+
+		if pool.Fragmentation < 0 {
+			continue
+		}
+		fragmented = append(fragmented, fmt.Sprintf("%s %.0f%%", pool.Name, pool.Fragmentation))
+
+		msg := fmt.Sprintf("pool %s at %.0f%% fragmentation", pool.Name, pool.Fragmentation)
+		if plugin.FragmentationCritical > 0 && pool.Fragmentation >= plugin.FragmentationCritical {
+			criticals = append(criticals, msg)
+		} else if plugin.FragmentationWarning > 0 && pool.Fragmentation >= plugin.FragmentationWarning {
+			warnings = append(warnings, msg)
+		}
+	}
+
+	for _, dataset := range datasets {
+		pool := strings.SplitN(dataset.Name, "/", 2)[0]
+		if !checked[pool] || skip(dataset.Name, plugin.IgnoreDatasets, plugin.IncludeDatasets) {
+			continue
+		}
+
+		name := "dataset " + dataset.Name
+		if limit := datasetLimits(dataset); limit != "" {
+			name += " (" + limit + ")"
+		}
+		usage := &disk.UsageStat{
+			Total:       dataset.Used + dataset.Avail,
+			Used:        dataset.Used,
+			Free:        dataset.Avail,
+			UsedPercent: dataset.UsedPercent(),
+		}
+		crit, warn := capacity.Evaluate(name, usage, thresholdsFor(dataset.Name), scaling())
+		criticals = append(criticals, crit...)
+		warnings = append(warnings, warn...)
+	}
+
+	if len(fragmented) > 0 {
+		fragmentation = fmt.Sprintf(" (fragmentation: %s)", strings.Join(fragmented, ", "))
+	}
+	return criticals, warnings, fragmentation
+}
+
+// skip reports whether name is left out by the ignore and include patterns
+func skip(name string, ignore, include []string) bool {
+	if filter.MatchAny(ignore, name) {
+		return true
+	}
+	return len(include) > 0 && !filter.MatchAny(include, name)
+}
+
+func defaultThresholds() capacity.Thresholds {
+	return capacity.Thresholds{
+		Warning:      plugin.Warning,
+		Critical:     plugin.Critical,
+		WarningFree:  plugin.warningFree,
+		CriticalFree: plugin.criticalFree,
+	}
+}
+
+func scaling() capacity.Scaling {
+	return capacity.Scaling{
+		Magic:   plugin.Magic,
+		Normal:  plugin.Normal,
+		Minimum: plugin.Minimum,
+	}
+}
+
+// thresholdsFor returns the thresholds of the first rule matching the pool
+// or dataset, or the command-line thresholds if no rule matches. Rules match
+// on the dataset name and the zfs filesystem type; ZFS mountpoints are not
+// looked up.
+func thresholdsFor(dataset string) capacity.Thresholds {
+	return capacity.For(rules, capacity.Target{Fstype: "zfs", Dataset: dataset}, defaultThresholds())
+}
+
+// datasetLimits describes the quotas and reservations that apply to the dataset
+func datasetLimits(d Dataset) string {
+	var limits []string
+	if d.Quota > 0 {
+		limits = append(limits, "quota "+bytesize.Format(d.Quota))
+	}
+	if d.RefQuota > 0 {
+		limits = append(limits, "refquota "+bytesize.Format(d.RefQuota))
+	}
+	if d.Reservation > 0 {
+		limits = append(limits, "reservation "+bytesize.Format(d.Reservation))
+	}
+	if d.RefReservation > 0 {
+		limits = append(limits, "refreservation "+bytesize.Format(d.RefReservation))
+	}
+	return strings.Join(limits, ", ")
+}
+
+func parseZpoolList(output string) ([]Pool, error) {
+	var pools []Pool
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("unexpected zpool line %q", line)
+		}
+
+		pool := Pool{Name: fields[0], Health: fields[6]}
+		var err error
+		if pool.Size, err = parseValue(fields[1]); err != nil {
+			return nil, err
+		}
+		if pool.Alloc, err = parseValue(fields[2]); err != nil {
+			return nil, err
+		}
+		if pool.Free, err = parseValue(fields[3]); err != nil {
+			return nil, err
+		}
+		if pool.Fragmentation, err = parsePercent(fields[4]); err != nil {
+			return nil, err
+		}
+		if pool.Capacity, err = parsePercent(fields[5]); err != nil {
+			return nil, err
+		}
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+func parseZfsList(output string) ([]Dataset, error) {
+	var datasets []Dataset
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("unexpected zfs line %q", line)
+		}
+
+		dataset := Dataset{Name: fields[0]}
+		var err error
+		if dataset.Used, err = parseValue(fields[1]); err != nil {
+			return nil, err
+		}
+		if dataset.Avail, err = parseValue(fields[2]); err != nil {
+			return nil, err
+		}
+		if dataset.Quota, err = parseValue(fields[3]); err != nil {
+			return nil, err
+		}
+		if dataset.RefQuota, err = parseValue(fields[4]); err != nil {
+			return nil, err
+		}
+		if dataset.Reservation, err = parseValue(fields[5]); err != nil {
+			return nil, err
+		}
+		if dataset.RefReservation, err = parseValue(fields[6]); err != nil {
+			return nil, err
+		}
+		datasets = append(datasets, dataset)
+	}
+	return datasets, nil
+}
+
+// parseValue parses a -p (parsable) byte value, where "-" and "none" mean
+// the property does not apply
+func parseValue(s string) (uint64, error) {
+	if s == "-" || s == "none" {
+		return 0, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// parsePercent parses a percentage column, returning -1 for "-"
+func parsePercent(s string) (float64, error) {
+	s = strings.TrimSuffix(s, "%")
+	if s == "-" {
+		return -1, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nmollerup/sensu-check-disk/internal/capacity"
+)
+
+func TestMain(m *testing.M) {
+	// Option defaults are normally applied by the plugin SDK
+	plugin.Magic = 1.0
+	plugin.Normal = 20
+	plugin.Minimum = 100
+	os.Exit(m.Run())
+}
+
+const zpoolOutput = "tank\t1000000000000\t850000000000\t150000000000\t42\t85\tONLINE\n" +
+	"rpool\t100000000000\t20000000000\t80000000000\t-\t20\tONLINE\n"
+
+const zfsOutput = "tank\t850000000000\t100000000000\t0\t0\t0\t0\n" +
+	"tank/home\t95000000000\t5000000000\t100000000000\t0\t0\t0\n" +
+	"tank/vm-disk\t40000000000\t100000000000\t-\t-\t0\t42949672960\n" +
+	"rpool\t20000000000\t76000000000\t0\t0\t0\t0\n"
+
+func TestParseZpoolList(t *testing.T) {
+	pools, err := parseZpoolList(zpoolOutput)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pools) != 2 {
+		t.Fatalf("expected 2 pools, got %d", len(pools))
+	}
+	if pools[0].Name != "tank" || pools[0].Capacity != 85 || pools[0].Fragmentation != 42 || pools[0].Health != "ONLINE" {
+		t.Errorf("unexpected pool: %+v", pools[0])
+	}
+	if pools[1].Fragmentation != -1 {
+		t.Errorf("expected unreported fragmentation to be -1, got %.0f", pools[1].Fragmentation)
+	}
+}
+
+func TestParseZfsList(t *testing.T) {
+	datasets, err := parseZfsList(zfsOutput)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(datasets) != 4 {
+		t.Fatalf("expected 4 datasets, got %d", len(datasets))
+	}
+	if datasets[1].Quota != 100000000000 || datasets[1].UsedPercent() != 95 {
+		t.Errorf("unexpected dataset: %+v", datasets[1])
+	}
+	if datasets[2].Quota != 0 || datasets[2].RefQuota != 0 || datasets[2].RefReservation != 40<<30 {
+		t.Errorf("expected volume without quotas and with a refreservation, got %+v", datasets[2])
+	}
+}
+
+func TestParseZpoolList_Malformed(t *testing.T) {
+	if _, err := parseZpoolList("tank\t100\n"); err == nil {
+		t.Error("expected error for truncated zpool line")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	plugin.Warning = 80
+	plugin.Critical = 90
+	plugin.FragmentationWarning = 40
+	plugin.FragmentationCritical = 0
+	plugin.IgnoreDatasets = nil
+
+	pools, _ := parseZpoolList(zpoolOutput)
+	datasets, _ := parseZfsList(zfsOutput)
+
+	criticals, warnings, fragmentation := evaluate(pools, datasets)
+
+	wantCriticals := []string{"dataset tank/home (quota 93.13 GiB) at 95.00% disk usage"}
+	wantWarnings := []string{"pool tank at 85.00% disk usage", "pool tank at 42% fragmentation", "dataset tank at 89.47% disk usage"}
+
+	if len(criticals) != len(wantCriticals) || criticals[0] != wantCriticals[0] {
+		t.Errorf("expected criticals %v, got %v", wantCriticals, criticals)
+	}
+	if len(warnings) != len(wantWarnings) {
+		t.Fatalf("expected warnings %v, got %v", wantWarnings, warnings)
+	}
+	for i := range wantWarnings {
+		if warnings[i] != wantWarnings[i] {
+			t.Errorf("expected warning %q, got %q", wantWarnings[i], warnings[i])
+		}
+	}
+	if fragmentation != " (fragmentation: tank 42%)" {
+		t.Errorf("unexpected fragmentation summary %q", fragmentation)
+	}
+}
+
+func TestEvaluate_RulesAndFreeSpace(t *testing.T) {
+	plugin.Warning = 80
+	plugin.Critical = 90
+	plugin.FragmentationWarning = 0
+	plugin.IncludePools = []string{"tank"}
+	plugin.criticalFree = 100 << 30
+	defer func() {
+		plugin.IncludePools = nil
+		plugin.criticalFree = 0
+		rules = nil
+	}()
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	data := `{"rules": [
+		{"dataset": "tank/home", "warning": 96, "critical": 99, "critical_free": "1GiB"},
+		{"dataset": "tank", "warning": 90, "critical": 95}
+	]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if rules, err = capacity.LoadRules(path, defaultThresholds()); err != nil {
+		t.Fatalf("unexpected error loading rules: %v", err)
+	}
+
+	pools, _ := parseZpoolList(zpoolOutput)
+	datasets, _ := parseZfsList(zfsOutput)
+	criticals, warnings, _ := evaluate(pools, datasets)
+
+	// tank/home is within its rule, the other datasets fall short of the
+	// global --critical-free, and rpool is not included
+	wantCriticals := []string{"dataset tank has 93.13 GiB free", "dataset tank/vm-disk (refreservation 40.00 GiB) has 93.13 GiB free"}
+	if len(criticals) != len(wantCriticals) {
+		t.Fatalf("expected criticals %v, got %v", wantCriticals, criticals)
+	}
+	for i := range wantCriticals {
+		if criticals[i] != wantCriticals[i] {
+			t.Errorf("expected critical %q, got %q", wantCriticals[i], criticals[i])
+		}
+	}
+	if len(warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", warnings)
+	}
+}
+
+func TestEvaluate_Health(t *testing.T) {
+	plugin.Warning = 80
+	plugin.Critical = 90
+	plugin.FragmentationWarning = 0
+
+	pools := []Pool{
+		{Name: "tank", Size: 100, Free: 100, Fragmentation: -1, Health: "DEGRADED"},
+		{Name: "backup", Size: 100, Free: 100, Fragmentation: -1, Health: "SUSPENDED"},
+		{Name: "rpool", Size: 100, Free: 100, Fragmentation: -1, Health: "ONLINE"},
+	}
+	criticals, warnings, _ := evaluate(pools, nil)
+
+	if len(criticals) != 1 || criticals[0] != "pool backup is SUSPENDED" {
+		t.Errorf("expected suspended pool critical, got %v", criticals)
+	}
+	if len(warnings) != 1 || warnings[0] != "pool tank is DEGRADED" {
+		t.Errorf("expected degraded pool warning, got %v", warnings)
+	}
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/nmollerup/sensu-check-disk/internal/btrfs"
+	"github.com/nmollerup/sensu-check-disk/internal/filter"
+	"github.com/nmollerup/sensu-check-disk/internal/hostroot"
+	"github.com/nmollerup/sensu-check-disk/internal/kubelet"
+	"github.com/nmollerup/sensu-check-disk/internal/statfs"
 	corev2 "github.com/sensu/core/v2"
 	"github.com/sensu/sensu-plugin-sdk/sensu"
 	"github.com/shirou/gopsutil/v3/disk"
@@ -12,11 +18,22 @@ import (
 // Config represents the metrics plugin config
 type Config struct {
 	sensu.PluginConfig
-	Scheme       string
-	IgnorePaths  []string
-	IncludePaths []string
-	IgnoreTypes  []string
-	IncludeTypes []string
+	Scheme         string
+	Profile        string
+	IgnorePaths    []string
+	IncludePaths   []string
+	IgnoreTypes    []string
+	IncludeTypes   []string
+	IgnoreDevices  []string
+	IncludeDevices []string
+	Concurrency    int
+	MountTimeout   float64
+	DedupeBy       string
+	Btrfs          bool
+	HostRoot       string
+	Kubernetes     bool
+	IgnoreLabels   []string
+	IncludeLabels  []string
 }
 
 var (
@@ -37,34 +54,110 @@ var (
 			Usage:     "Metric naming scheme prefix",
 			Value:     &plugin.Scheme,
 		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "Profile",
+			Argument: "profile",
+			Default:  filter.ProfileAll,
+			Allow:    filter.Profiles,
+			Usage:    "Named set of filesystem type and mount option rules selecting the partitions to check, refined by the other filters: all, local, physical or network",
+			Value:    &plugin.Profile,
+		},
 		&sensu.SlicePluginConfigOption[string]{
 			Path:      "IgnorePaths",
 			Argument:  "ignore-paths",
 			Shorthand: "i",
-			Usage:     "Comma-separated list of mount paths to ignore",
+			Usage:     "Comma-separated list of mount paths to ignore (globs and ~regex supported)",
 			Value:     &plugin.IgnorePaths,
 		},
 		&sensu.SlicePluginConfigOption[string]{
 			Path:      "IncludePaths",
 			Argument:  "include-paths",
 			Shorthand: "I",
-			Usage:     "Comma-separated list of mount paths to include (if set, only these are checked)",
+			Usage:     "Comma-separated list of mount paths to include (if set, only these are checked; globs and ~regex supported)",
 			Value:     &plugin.IncludePaths,
 		},
 		&sensu.SlicePluginConfigOption[string]{
 			Path:      "IgnoreTypes",
 			Argument:  "ignore-types",
 			Shorthand: "x",
-			Usage:     "Comma-separated list of filesystem types to ignore",
+			Usage:     "Comma-separated list of filesystem types to ignore (globs and ~regex supported)",
 			Value:     &plugin.IgnoreTypes,
 		},
 		&sensu.SlicePluginConfigOption[string]{
 			Path:      "IncludeTypes",
 			Argument:  "include-types",
 			Shorthand: "t",
-			Usage:     "Comma-separated list of filesystem types to include (if set, only these are checked)",
+			Usage:     "Comma-separated list of filesystem types to include (if set, only these are checked; globs and ~regex supported)",
 			Value:     &plugin.IncludeTypes,
 		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:      "IgnoreDevices",
+			Argument:  "ignore-devices",
+			Shorthand: "d",
+			Usage:     "Comma-separated list of devices to ignore (globs and ~regex supported)",
+			Value:     &plugin.IgnoreDevices,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:      "IncludeDevices",
+			Argument:  "include-devices",
+			Shorthand: "D",
+			Usage:     "Comma-separated list of devices to include (if set, only these are checked; globs and ~regex supported)",
+			Value:     &plugin.IncludeDevices,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "Concurrency",
+			Argument: "concurrency",
+			Default:  4,
+			Usage:    "Number of mountpoints to query in parallel",
+			Value:    &plugin.Concurrency,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "MountTimeout",
+			Argument: "mount-timeout",
+			Default:  10,
+			Usage:    "Seconds to wait for the usage of a single mountpoint (0 to wait forever)",
+			Value:    &plugin.MountTimeout,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "DedupeBy",
+			Argument: "dedupe-by",
+			Default:  statfs.DedupeNone,
+			Allow:    statfs.DedupeModes,
+			Usage:    "Evaluate each filesystem once by grouping mountpoints by device or filesystem: none, device or filesystem",
+			Value:    &plugin.DedupeBy,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "Btrfs",
+			Argument: "btrfs",
+			Usage:    "Also output btrfs data, metadata and unallocated space from /sys/fs/btrfs",
+			Value:    &plugin.Btrfs,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "HostRoot",
+			Argument: "host-root",
+			Env:      "HOST_ROOT",
+			Usage:    "Directory the host's root filesystem is mounted at when running in a container (e.g. /host)",
+			Value:    &plugin.HostRoot,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "Kubernetes",
+			Argument: "kubernetes",
+			Default:  false,
+			Usage:    "Name metrics of kubelet volume mounts after their PV, or pod UID and volume, instead of their mountpoint",
+			Value:    &plugin.Kubernetes,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "IgnoreLabels",
+			Argument: "ignore-labels",
+			Usage:    "Comma-separated list of label=pattern entries; kubelet volumes with a matching pod_uid, volume, plugin or pv label are ignored",
+			Value:    &plugin.IgnoreLabels,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "IncludeLabels",
+			Argument: "include-labels",
+			Usage:    "Comma-separated list of label=pattern entries; if set, only kubelet volumes with a matching label are checked (e.g. pv=* for PV-backed volumes)",
+			Value:    &plugin.IncludeLabels,
+		},
 	}
 )
 
@@ -74,47 +167,68 @@ func main() {
 }
 
 func checkArgs(event *corev2.Event) error {
-	return nil
+	hostroot.Set(plugin.HostRoot)
+
+	if plugin.Concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1")
+	}
+	if plugin.MountTimeout < 0 {
+		return fmt.Errorf("--mount-timeout must not be negative")
+	}
+	if err := partitionFilter().Validate(); err != nil {
+		return err
+	}
+	return labelFilter().Validate()
 }
 
 func executeMetric(event *corev2.Event) error {
-	partitions, err := disk.Partitions(false)
+	partitions, err := hostroot.Partitions(partitionFilter().AllPartitions())
 	if err != nil {
 		return fmt.Errorf("failed to get disk partitions: %v", err)
 	}
 
 	timestamp := time.Now().Unix()
 
+	var selected []disk.PartitionStat
 	for _, partition := range partitions {
-		// Skip if filesystem type should be ignored
-		if shouldIgnoreType(partition.Fstype) {
+		if partitionFilter().Skip(partition) || labelFilter().Skip(partition.Mountpoint) {
 			continue
 		}
+		selected = append(selected, partition)
+	}
 
-		// Skip if not in include types (when include types is specified)
-		if len(plugin.IncludeTypes) > 0 && !contains(plugin.IncludeTypes, partition.Fstype) {
-			continue
-		}
+	timeout := time.Duration(plugin.MountTimeout * float64(time.Second))
+	results := statfs.Dedupe(statfs.Collect(selected, plugin.Concurrency, timeout), plugin.DedupeBy)
+	written := make(map[string]bool)
+	for _, result := range results {
+		partition, usage := result.Partition, result.Usage
 
-		// Skip if mount point should be ignored
-		if contains(plugin.IgnorePaths, partition.Mountpoint) {
+		// Sanitize mount point for metric name
+		sanitizedMount := sanitizePath(partition.Mountpoint)
+		shared := false
+		if plugin.Kubernetes {
+			if volume, ok := kubelet.Parse(partition.Mountpoint); ok {
+				sanitizedMount = volume.MetricPath()
+				shared = true
+			}
+		}
+		// All mounts of a PV share its metric path, so its usage is written
+		// only once
+		if shared && written[sanitizedMount] {
 			continue
 		}
 
-		// Skip if not in include paths (when include paths is specified)
-		if len(plugin.IncludePaths) > 0 && !contains(plugin.IncludePaths, partition.Mountpoint) {
+		if errors.Is(result.Err, statfs.ErrTimeout) {
+			// Leave out the usage of hung mounts rather than blocking every
+			// other metric, and flag them with a metric of their own
+			fmt.Printf("%s.%s.timeout 1 %d\n", plugin.Scheme, sanitizedMount, timestamp)
 			continue
 		}
-
-		usage, err := disk.Usage(partition.Mountpoint)
-		if err != nil {
+		if result.Err != nil {
 			// Skip partitions we can't read (e.g., permission issues)
 			continue
 		}
 
-		// Sanitize mount point for metric name
-		sanitizedMount := sanitizePath(partition.Mountpoint)
-
 		// Output all available metrics in Graphite plaintext format
 		fmt.Printf("%s.%s.total %d %d\n", plugin.Scheme, sanitizedMount, usage.Total, timestamp)
 		fmt.Printf("%s.%s.used %d %d\n", plugin.Scheme, sanitizedMount, usage.Used, timestamp)
@@ -129,22 +243,49 @@ func executeMetric(event *corev2.Event) error {
 			inodesPercent := float64(usage.InodesUsed) / float64(usage.InodesTotal) * 100.0
 			fmt.Printf("%s.%s.inodes_percent_used %.2f %d\n", plugin.Scheme, sanitizedMount, inodesPercent, timestamp)
 		}
+
+		if shared {
+			written[sanitizedMount] = true
+		}
+
+		// Btrfs chunk allocation metrics
+		if plugin.Btrfs && partition.Fstype == "btrfs" {
+			allocation, err := btrfs.ForDevice(hostroot.Sys(), partition.Device)
+			if err != nil {
+				// Leave out the allocation series rather than writing the
+				// error into the metric output
+				continue
+			}
+			fmt.Printf("%s.%s.btrfs.data_total %d %d\n", plugin.Scheme, sanitizedMount, allocation.Data.TotalBytes, timestamp)
+			fmt.Printf("%s.%s.btrfs.data_used %d %d\n", plugin.Scheme, sanitizedMount, allocation.Data.BytesUsed, timestamp)
+			fmt.Printf("%s.%s.btrfs.data_percent_used %.2f %d\n", plugin.Scheme, sanitizedMount, allocation.UsedPercent(allocation.Data), timestamp)
+			fmt.Printf("%s.%s.btrfs.metadata_total %d %d\n", plugin.Scheme, sanitizedMount, allocation.Metadata.TotalBytes, timestamp)
+			fmt.Printf("%s.%s.btrfs.metadata_used %d %d\n", plugin.Scheme, sanitizedMount, allocation.Metadata.BytesUsed, timestamp)
+			fmt.Printf("%s.%s.btrfs.metadata_percent_used %.2f %d\n", plugin.Scheme, sanitizedMount, allocation.UsedPercent(allocation.Metadata), timestamp)
+			fmt.Printf("%s.%s.btrfs.unallocated %d %d\n", plugin.Scheme, sanitizedMount, allocation.Unallocated(), timestamp)
+		}
 	}
 
 	return nil
 }
 
-func shouldIgnoreType(fstype string) bool {
-	return contains(plugin.IgnoreTypes, fstype)
+func labelFilter() kubelet.Filter {
+	return kubelet.Filter{
+		IgnoreLabels:  plugin.IgnoreLabels,
+		IncludeLabels: plugin.IncludeLabels,
+	}
 }
 
-func contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
-		}
+func partitionFilter() filter.Filter {
+	return filter.Filter{
+		Profile:        plugin.Profile,
+		IgnorePaths:    plugin.IgnorePaths,
+		IncludePaths:   plugin.IncludePaths,
+		IgnoreTypes:    plugin.IgnoreTypes,
+		IncludeTypes:   plugin.IncludeTypes,
+		IgnoreDevices:  plugin.IgnoreDevices,
+		IncludeDevices: plugin.IncludeDevices,
 	}
-	return false
 }
 
 func sanitizePath(path string) string {
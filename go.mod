@@ -6,6 +6,8 @@ require (
 	github.com/sensu/core/v2 v2.16.1
 	github.com/sensu/sensu-plugin-sdk v0.19.0
 	github.com/shirou/gopsutil/v3 v3.24.5
+	golang.org/x/sys v0.31.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -40,12 +42,10 @@ require (
 	github.com/yusufpapurcu/wmi v1.2.4 // indirect
 	go.etcd.io/etcd/api/v3 v3.5.5 // indirect
 	golang.org/x/net v0.38.0 // indirect
-	golang.org/x/sys v0.31.0 // indirect
 	golang.org/x/text v0.23.0 // indirect
 	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
 	google.golang.org/grpc v1.56.3 // indirect
 	google.golang.org/protobuf v1.33.0 // indirect
 	gopkg.in/ini.v1 v1.51.0 // indirect
 	gopkg.in/sourcemap.v1 v1.0.5 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -0,0 +1,144 @@
+// Package btrfs reads chunk allocation figures of mounted btrfs filesystems
+// from /sys/fs/btrfs, which statfs does not reflect.
+package btrfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nmollerup/sensu-check-disk/internal/fstab"
+)
+
+// Space is the allocation of one block group type (data, metadata or system)
+type Space struct {
+	// TotalBytes is the logical size of the allocated chunks
+	TotalBytes uint64
+	// BytesUsed is the logical space used within the allocated chunks
+	BytesUsed uint64
+	// DiskTotal is the raw device space taken by the allocated chunks,
+	// which is larger than TotalBytes for RAID1, DUP and similar profiles
+	DiskTotal uint64
+}
+
+// Ratio is the raw bytes consumed per logical byte under the RAID profile
+func (s Space) Ratio() float64 {
+	if s.TotalBytes == 0 || s.DiskTotal == 0 {
+		return 1
+	}
+	return float64(s.DiskTotal) / float64(s.TotalBytes)
+}
+
+// Allocation describes the chunk allocation of a btrfs filesystem
+type Allocation struct {
+	UUID     string
+	Data     Space
+	Metadata Space
+	System   Space
+	// DeviceBytes is the combined size of all member devices
+	DeviceBytes uint64
+}
+
+// Unallocated is the raw device space not yet allocated to any chunk
+func (a Allocation) Unallocated() uint64 {
+	allocated := a.Data.DiskTotal + a.Metadata.DiskTotal + a.System.DiskTotal
+	if allocated >= a.DeviceBytes {
+		return 0
+	}
+	return a.DeviceBytes - allocated
+}
+
+// Capacity is the logical space the block group type can grow to: its
+// allocated chunks plus the unallocated space under its RAID profile
+func (a Allocation) Capacity(s Space) uint64 {
+	return s.TotalBytes + uint64(float64(a.Unallocated())/s.Ratio())
+}
+
+// UsedPercent is the percentage of Capacity in use. Metadata can be 100%
+// used within its chunks while plenty of unallocated space remains; only
+// when that is gone does btrfs return ENOSPC.
+func (a Allocation) UsedPercent(s Space) float64 {
+	capacity := a.Capacity(s)
+	if capacity == 0 {
+		return 0
+	}
+	return float64(s.BytesUsed) / float64(capacity) * 100.0
+}
+
+// ForDevice finds the btrfs filesystem that device (e.g. /dev/sda1 or
+// /dev/dm-0) belongs to below sysfs (normally /sys) and reads its allocation
+func ForDevice(sysfs, device string) (*Allocation, error) {
+	uuid, err := UUID(sysfs, device)
+	if err != nil {
+		return nil, err
+	}
+	return Read(filepath.Join(sysfs, "fs", "btrfs", uuid))
+}
+
+// UUID returns the UUID of the btrfs filesystem that device, such as
+// /dev/sda1, is a member of. Symlinks such as /dev/mapper/luks-root are
+// followed to the kernel name, e.g. dm-0, that sysfs lists members under.
+func UUID(sysfs, device string) (string, error) {
+	if node, err := fstab.Resolve(device); err == nil {
+		device = node
+	}
+	name := filepath.Base(device)
+	dirs, err := filepath.Glob(filepath.Join(sysfs, "fs", "btrfs", "*", "devices", name))
+	if err != nil {
+		return "", err
+	}
+	if len(dirs) == 0 {
+		return "", fmt.Errorf("no btrfs filesystem found for %s", device)
+	}
+	return filepath.Base(filepath.Dir(filepath.Dir(dirs[0]))), nil
+}
+
+// Read reads the allocation of the filesystem in dir, a /sys/fs/btrfs/<uuid>
+// directory
+func Read(dir string) (*Allocation, error) {
+	a := &Allocation{UUID: filepath.Base(dir)}
+
+	spaces := map[string]*Space{
+		"data":     &a.Data,
+		"metadata": &a.Metadata,
+		"system":   &a.System,
+	}
+	for name, space := range spaces {
+		base := filepath.Join(dir, "allocation", name)
+		var err error
+		if space.TotalBytes, err = readUint(filepath.Join(base, "total_bytes")); err != nil {
+			return nil, err
+		}
+		if space.BytesUsed, err = readUint(filepath.Join(base, "bytes_used")); err != nil {
+			return nil, err
+		}
+		if space.DiskTotal, err = readUint(filepath.Join(base, "disk_total")); err != nil {
+			return nil, err
+		}
+	}
+
+	devices, err := filepath.Glob(filepath.Join(dir, "devices", "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, device := range devices {
+		// Block device sizes are in 512 byte sectors
+		sectors, err := readUint(filepath.Join(device, "size"))
+		if err != nil {
+			return nil, err
+		}
+		a.DeviceBytes += sectors * 512
+	}
+
+	return a, nil
+}
+
+func readUint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
@@ -0,0 +1,91 @@
+package btrfs
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/nmollerup/sensu-check-disk/internal/devtest"
+)
+
+const gib = 1 << 30
+
+func writeSysfs(t *testing.T, root string, files map[string]uint64) {
+	t.Helper()
+	for name, value := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(strconv.FormatUint(value, 10)+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestForDevice(t *testing.T) {
+	sysfs := t.TempDir()
+	fs := "fs/btrfs/0a1b2c3d-uuid"
+
+	// RAID1 data and metadata across two 10 GiB devices, metadata full and
+	// only 1 GiB of raw space left unallocated
+	writeSysfs(t, sysfs, map[string]uint64{
+		fs + "/allocation/data/total_bytes":     8 * gib,
+		fs + "/allocation/data/bytes_used":      4 * gib,
+		fs + "/allocation/data/disk_total":      16 * gib,
+		fs + "/allocation/metadata/total_bytes": 1 * gib,
+		fs + "/allocation/metadata/bytes_used":  1 * gib,
+		fs + "/allocation/metadata/disk_total":  2 * gib,
+		fs + "/allocation/system/total_bytes":   gib / 2,
+		fs + "/allocation/system/bytes_used":    0,
+		fs + "/allocation/system/disk_total":    1 * gib,
+		fs + "/devices/sda1/size":               10 * gib / 512,
+		fs + "/devices/sdb1/size":               10 * gib / 512,
+	})
+
+	a, err := ForDevice(sysfs, "/dev/sdb1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if a.UUID != "0a1b2c3d-uuid" {
+		t.Errorf("expected UUID 0a1b2c3d-uuid, got %s", a.UUID)
+	}
+	if got := a.Unallocated(); got != 1*gib {
+		t.Errorf("expected 1 GiB unallocated, got %d", got)
+	}
+	// 1 GiB raw unallocated is 0.5 GiB logical under RAID1
+	if got := a.Capacity(a.Metadata); got != gib+gib/2 {
+		t.Errorf("expected metadata capacity of 1.5 GiB, got %d", got)
+	}
+	if got := a.UsedPercent(a.Metadata); got < 66.6 || got > 66.7 {
+		t.Errorf("expected metadata at 66.67%%, got %.2f", got)
+	}
+	if got := a.UsedPercent(a.Data); got < 47.0 || got > 47.1 {
+		t.Errorf("expected data at 47.06%%, got %.2f", got)
+	}
+}
+
+func TestForDevice_NotFound(t *testing.T) {
+	if _, err := ForDevice(t.TempDir(), "/dev/sda1"); err == nil {
+		t.Error("expected error for device without btrfs filesystem")
+	}
+}
+
+func TestUUID_Mapper(t *testing.T) {
+	devtest.FakeDev(t, map[string]string{"mapper/luks-root": "../dm-0"})
+
+	sysfs := t.TempDir()
+	writeSysfs(t, sysfs, map[string]uint64{
+		"fs/btrfs/0a1b2c3d-uuid/devices/dm-0/size": 10 * gib / 512,
+	})
+
+	uuid, err := UUID(sysfs, "/dev/mapper/luks-root")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uuid != "0a1b2c3d-uuid" {
+		t.Errorf("expected UUID 0a1b2c3d-uuid, got %s", uuid)
+	}
+}
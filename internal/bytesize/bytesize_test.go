@@ -0,0 +1,48 @@
+package bytesize
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tests := map[uint64]string{
+		0:           "0.00 B",
+		1023:        "1023.00 B",
+		1024:        "1.00 KiB",
+		3 << 29:     "1.50 GiB",
+		5 << 50:     "5.00 PiB",
+		1 << 62:     "4096.00 PiB",
+		100<<20 + 1: "100.00 MiB",
+	}
+	for in, want := range tests {
+		if got := Format(in); got != want {
+			t.Errorf("Format(%d) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  uint64
+	}{
+		{"5GiB", 5 << 30},
+		{"5G", 5 << 30},
+		{"500MB", 500 * 1000 * 1000},
+		{"1.5 TiB", 3 << 39},
+		{"1024", 1024},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%q) returned error: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+
+	if _, err := Parse("5 parsecs"); err == nil {
+		t.Error("expected error for unknown unit")
+	}
+}
@@ -0,0 +1,109 @@
+// Package capacity evaluates filesystem usage against the percentage, free
+// space and size-scaled thresholds shared by the capacity checks, and reads
+// the rules files that override them per mountpoint, filesystem type or
+// dataset.
+package capacity
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/nmollerup/sensu-check-disk/internal/bytesize"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// Thresholds holds the warning and critical levels applied to a filesystem
+type Thresholds struct {
+	Warning       float64
+	Critical      float64
+	InodeWarning  float64
+	InodeCritical float64
+	WarningFree   uint64
+	CriticalFree  uint64
+}
+
+// Scaling holds the --magic, --normal and --minimum options that adjust
+// percentage thresholds by filesystem size
+type Scaling struct {
+	Magic   float64
+	Normal  float64
+	Minimum float64
+}
+
+// Adjust scales a percentage threshold by filesystem size using the magic
+// factor formula from sensu-plugins-disk-checks: thresholds are raised for
+// filesystems larger than Normal and lowered for smaller ones, but
+// filesystems smaller than Minimum are left unadjusted. With the default
+// Minimum above Normal, thresholds are therefore only ever raised.
+func (s Scaling) Adjust(total uint64, percent float64) float64 {
+	if s.Magic == 1.0 || total == 0 {
+		return percent
+	}
+	sizeGiB := float64(total) / (1024 * 1024 * 1024)
+	if sizeGiB < s.Minimum {
+		return percent
+	}
+	hsize := sizeGiB / s.Normal
+	felt := math.Pow(hsize, s.Magic)
+	scale := felt / hsize
+	return 100 - ((100 - percent) * scale)
+}
+
+// Evaluate compares the byte and inode usage of the filesystem called name
+// against the given thresholds and returns the critical and warning messages
+// for it. Usage without inodes, as reported by btrfs, vfat and ZFS pools,
+// skips the inode thresholds.
+func Evaluate(name string, usage *disk.UsageStat, t Thresholds, s Scaling) (criticals []string, warnings []string) {
+	usedPercent := usage.UsedPercent
+	warning := s.Adjust(usage.Total, t.Warning)
+	critical := s.Adjust(usage.Total, t.Critical)
+
+	percentState := sensu.CheckStateOK
+	if usedPercent >= critical {
+		percentState = sensu.CheckStateCritical
+	} else if usedPercent >= warning {
+		percentState = sensu.CheckStateWarning
+	}
+
+	freeState := sensu.CheckStateOK
+	if t.CriticalFree > 0 && usage.Free < t.CriticalFree {
+		freeState = sensu.CheckStateCritical
+	} else if t.WarningFree > 0 && usage.Free < t.WarningFree {
+		freeState = sensu.CheckStateWarning
+	}
+
+	// Report whichever of the percentage and free space rules is stricter
+	var msg string
+	state := percentState
+	if percentState >= freeState {
+		msg = fmt.Sprintf("%s at %.2f%% disk usage", name, usedPercent)
+		if warning != t.Warning || critical != t.Critical {
+			msg += fmt.Sprintf(" (adjusted thresholds %.2f%%/%.2f%%)", warning, critical)
+		}
+	} else {
+		state = freeState
+		msg = fmt.Sprintf("%s has %s free", name, bytesize.Format(usage.Free))
+	}
+
+	switch state {
+	case sensu.CheckStateCritical:
+		criticals = append(criticals, msg)
+	case sensu.CheckStateWarning:
+		warnings = append(warnings, msg)
+	}
+
+	if usage.InodesTotal == 0 {
+		return criticals, warnings
+	}
+
+	inodesPercent := float64(usage.InodesUsed) / float64(usage.InodesTotal) * 100.0
+
+	if t.InodeCritical > 0 && inodesPercent >= t.InodeCritical {
+		criticals = append(criticals, fmt.Sprintf("%s at %.2f%% inode usage", name, inodesPercent))
+	} else if t.InodeWarning > 0 && inodesPercent >= t.InodeWarning {
+		warnings = append(warnings, fmt.Sprintf("%s at %.2f%% inode usage", name, inodesPercent))
+	}
+
+	return criticals, warnings
+}
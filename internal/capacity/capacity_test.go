@@ -0,0 +1,97 @@
+package capacity
+
+import (
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+func TestEvaluate_InodeCritical(t *testing.T) {
+	thresholds := Thresholds{Warning: 80, Critical: 90, InodeWarning: 80, InodeCritical: 90}
+
+	usage := &disk.UsageStat{
+		UsedPercent: 10,
+		InodesTotal: 100,
+		InodesUsed:  95,
+	}
+
+	criticals, warnings := Evaluate("/var/spool", usage, thresholds, Scaling{Magic: 1})
+	if len(criticals) != 1 || criticals[0] != "/var/spool at 95.00% inode usage" {
+		t.Errorf("expected one inode critical, got %v", criticals)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", warnings)
+	}
+}
+
+func TestEvaluate_ZeroInodes(t *testing.T) {
+	thresholds := Thresholds{Warning: 80, Critical: 90, InodeWarning: 1, InodeCritical: 2}
+
+	usage := &disk.UsageStat{
+		UsedPercent: 10,
+		InodesTotal: 0,
+	}
+
+	criticals, warnings := Evaluate("/boot/efi", usage, thresholds, Scaling{Magic: 1})
+	if len(criticals) != 0 || len(warnings) != 0 {
+		t.Errorf("expected filesystem without inodes to be skipped, got %v %v", criticals, warnings)
+	}
+}
+
+func TestEvaluate_InodesDisabled(t *testing.T) {
+	thresholds := Thresholds{Warning: 80, Critical: 90}
+
+	usage := &disk.UsageStat{
+		UsedPercent: 85,
+		InodesTotal: 100,
+		InodesUsed:  100,
+	}
+
+	criticals, warnings := Evaluate("/", usage, thresholds, Scaling{Magic: 1})
+	if len(criticals) != 0 {
+		t.Errorf("expected no criticals, got %v", criticals)
+	}
+	if len(warnings) != 1 || warnings[0] != "/ at 85.00% disk usage" {
+		t.Errorf("expected one disk usage warning, got %v", warnings)
+	}
+}
+
+func TestEvaluate_FreeSpaceStricter(t *testing.T) {
+	thresholds := Thresholds{Warning: 80, Critical: 90, WarningFree: 10 << 30, CriticalFree: 5 << 30}
+
+	usage := &disk.UsageStat{
+		Total:       100 << 30,
+		Free:        4 << 30,
+		UsedPercent: 85,
+	}
+
+	criticals, warnings := Evaluate("/data", usage, thresholds, Scaling{Magic: 1})
+	if len(criticals) != 1 || criticals[0] != "/data has 4.00 GiB free" {
+		t.Errorf("expected free space critical, got %v", criticals)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", warnings)
+	}
+}
+
+func TestScalingAdjust(t *testing.T) {
+	s := Scaling{Magic: 0.9, Normal: 20, Minimum: 100}
+
+	// Filesystems below Minimum are not adjusted
+	if got := s.Adjust(50<<30, 90); got != 90 {
+		t.Errorf("expected unadjusted threshold for small filesystem, got %.2f", got)
+	}
+
+	// Large filesystems get a higher threshold
+	got := s.Adjust(2000<<30, 90)
+	if got <= 90 || got >= 100 {
+		t.Errorf("expected raised threshold for large filesystem, got %.2f", got)
+	}
+
+	// Filesystems between Minimum and Normal get a lower threshold, which
+	// the defaults never allow
+	s.Normal, s.Minimum = 100, 10
+	if got := s.Adjust(50<<30, 90); got >= 90 {
+		t.Errorf("expected lowered threshold for filesystem below Normal, got %.2f", got)
+	}
+}
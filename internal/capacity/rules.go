@@ -0,0 +1,164 @@
+package capacity
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nmollerup/sensu-check-disk/internal/bytesize"
+	"github.com/nmollerup/sensu-check-disk/internal/filter"
+	"github.com/nmollerup/sensu-check-disk/internal/kubelet"
+	"gopkg.in/yaml.v2"
+)
+
+// Rule overrides the command-line thresholds for filesystems matching its
+// mountpoint, filesystem type, dataset and kubelet volume label patterns.
+// Unset thresholds fall back to the command-line values.
+type Rule struct {
+	Mountpoint    string   `json:"mountpoint" yaml:"mountpoint"`
+	Fstype        string   `json:"fstype" yaml:"fstype"`
+	Dataset       string   `json:"dataset" yaml:"dataset"`
+	PodUID        string   `json:"pod_uid" yaml:"pod_uid"`
+	Volume        string   `json:"volume" yaml:"volume"`
+	Plugin        string   `json:"plugin" yaml:"plugin"`
+	PV            string   `json:"pv" yaml:"pv"`
+	Warning       *float64 `json:"warning" yaml:"warning"`
+	Critical      *float64 `json:"critical" yaml:"critical"`
+	InodeWarning  *float64 `json:"inode_warning" yaml:"inode_warning"`
+	InodeCritical *float64 `json:"inode_critical" yaml:"inode_critical"`
+	WarningFree   string   `json:"warning_free" yaml:"warning_free"`
+	CriticalFree  string   `json:"critical_free" yaml:"critical_free"`
+
+	warningFree  uint64
+	criticalFree uint64
+}
+
+// RulesConfig is the format of the file given with --rules-file, read as
+// YAML when the file name ends in .yaml or .yml and as JSON otherwise
+type RulesConfig struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// Target is a filesystem rules are matched against. Fields that do not
+// apply are left empty, so rules matching on them do not apply either.
+type Target struct {
+	Mountpoint string
+	Fstype     string
+	// Dataset is the ZFS pool or dataset name
+	Dataset string
+	// Labels are the kubelet volume labels of the mountpoint
+	Labels map[string]string
+}
+
+// LoadRules reads the rules file at path and checks that each rule, applied
+// to the command-line thresholds in defaults, leaves consistent thresholds
+func LoadRules(path string, defaults Thresholds) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config RulesConfig
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &config)
+	default:
+		err = json.Unmarshal(data, &config)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		patterns := []string{rule.Mountpoint, rule.Fstype, rule.Dataset, rule.PodUID, rule.Volume, rule.Plugin, rule.PV}
+		if strings.Join(patterns, "") == "" {
+			return nil, fmt.Errorf("rule %d must set mountpoint, fstype, dataset or a volume label", i+1)
+		}
+		if err := filter.Validate(patterns); err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+		if rule.WarningFree != "" {
+			if rule.warningFree, err = bytesize.Parse(rule.WarningFree); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid warning_free: %v", i+1, err)
+			}
+		}
+		if rule.CriticalFree != "" {
+			if rule.criticalFree, err = bytesize.Parse(rule.CriticalFree); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid critical_free: %v", i+1, err)
+			}
+		}
+		t := rule.Apply(defaults)
+		if t.Warning >= t.Critical {
+			return nil, fmt.Errorf("rule %d: warning must be less than critical", i+1)
+		}
+		if t.InodeWarning > 0 && t.InodeCritical > 0 && t.InodeWarning >= t.InodeCritical {
+			return nil, fmt.Errorf("rule %d: inode_warning must be less than inode_critical", i+1)
+		}
+		if t.WarningFree > 0 && t.CriticalFree > 0 && t.WarningFree <= t.CriticalFree {
+			return nil, fmt.Errorf("rule %d: warning_free must be greater than critical_free", i+1)
+		}
+	}
+
+	return config.Rules, nil
+}
+
+// Matches reports whether the rule applies to the target
+func (r Rule) Matches(target Target) bool {
+	for _, m := range []struct{ pattern, value string }{
+		{r.Fstype, target.Fstype},
+		{r.Mountpoint, target.Mountpoint},
+		{r.Dataset, target.Dataset},
+	} {
+		if m.pattern != "" && !filter.Match(m.pattern, m.value) {
+			return false
+		}
+	}
+	for name, pattern := range map[string]string{
+		kubelet.LabelPodUID: r.PodUID,
+		kubelet.LabelVolume: r.Volume,
+		kubelet.LabelPlugin: r.Plugin,
+		kubelet.LabelPV:     r.PV,
+	} {
+		if pattern != "" && !kubelet.MatchLabel(name, pattern, target.Labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns t with any thresholds set in the rule overridden
+func (r Rule) Apply(t Thresholds) Thresholds {
+	if r.Warning != nil {
+		t.Warning = *r.Warning
+	}
+	if r.Critical != nil {
+		t.Critical = *r.Critical
+	}
+	if r.InodeWarning != nil {
+		t.InodeWarning = *r.InodeWarning
+	}
+	if r.InodeCritical != nil {
+		t.InodeCritical = *r.InodeCritical
+	}
+	if r.WarningFree != "" {
+		t.WarningFree = r.warningFree
+	}
+	if r.CriticalFree != "" {
+		t.CriticalFree = r.criticalFree
+	}
+	return t
+}
+
+// For returns the thresholds of the first rule matching the target, or
+// defaults if no rule matches
+func For(rules []Rule, target Target, defaults Thresholds) Thresholds {
+	for _, rule := range rules {
+		if rule.Matches(target) {
+			return rule.Apply(defaults)
+		}
+	}
+	return defaults
+}
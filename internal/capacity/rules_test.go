@@ -0,0 +1,86 @@
+package capacity
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var defaults = Thresholds{Warning: 80, Critical: 90}
+
+func TestLoadRules_InvalidThresholds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(`{"rules": [{"mountpoint": "/boot", "warning": 95}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadRules(path, defaults); err == nil {
+		t.Error("expected error for rule with warning above critical")
+	}
+}
+
+func TestLoadRules_YAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	data := `rules:
+  - mountpoint: /boot
+    warning: 70
+    critical: 80
+  - fstype: xfs
+    inode_warning: 85
+    critical_free: 20GiB
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadRules(path, defaults)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(loaded) != 2 || loaded[0].Mountpoint != "/boot" || *loaded[0].Critical != 80 {
+		t.Fatalf("unexpected rules %+v", loaded)
+	}
+	if *loaded[1].InodeWarning != 85 || loaded[1].criticalFree != 20<<30 {
+		t.Errorf("unexpected xfs rule %+v", loaded[1])
+	}
+
+	// JSON is valid YAML, but a .json file is never read as YAML
+	path = filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRules(path, defaults); err == nil {
+		t.Error("expected error for YAML in a .json file")
+	}
+}
+
+func TestFor_Dataset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	data := `{"rules": [
+		{"dataset": "tank/backup*", "warning": 95, "critical": 98},
+		{"mountpoint": "/srv", "critical": 97}
+	]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := LoadRules(path, defaults)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		target Target
+		want   Thresholds
+	}{
+		{Target{Fstype: "zfs", Dataset: "tank/backup/db"}, Thresholds{Warning: 95, Critical: 98}},
+		{Target{Mountpoint: "/srv", Fstype: "zfs", Dataset: "tank/srv"}, Thresholds{Warning: 80, Critical: 97}},
+		// A rule matching on a field the target does not have never applies
+		{Target{Fstype: "zfs", Dataset: "tank/srv"}, defaults},
+		{Target{Mountpoint: "/backup", Fstype: "ext4"}, defaults},
+	}
+	for _, tt := range tests {
+		if got := For(rules, tt.target, defaults); got != tt.want {
+			t.Errorf("For(%+v) = %+v, want %+v", tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Package devtest builds fake /dev trees for tests of code that resolves
+// device specs below hostroot.Dev.
+package devtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// FakeDev creates a /dev tree in a temporary directory and points HOST_DEV at
+// it for the rest of the test. links maps symlinks, such as
+// disk/by-uuid/<uuid> or mapper/<name>, to their targets relative to the
+// link, e.g. ../../sda1; the device nodes they point at are created as empty
+// files, and so are the extra nodes given. It returns the directory.
+func FakeDev(t *testing.T, links map[string]string, nodes ...string) string {
+	t.Helper()
+	dev := t.TempDir()
+	t.Setenv("HOST_DEV", dev)
+
+	create := func(node string) {
+		if err := os.MkdirAll(filepath.Dir(node), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(node, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, node := range nodes {
+		create(filepath.Join(dev, node))
+	}
+	for link, target := range links {
+		path := filepath.Join(dev, link)
+		if node := filepath.Join(filepath.Dir(path), target); !exists(node) {
+			create(node)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dev
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
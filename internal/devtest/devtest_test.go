@@ -0,0 +1,34 @@
+package devtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFakeDev(t *testing.T) {
+	dev := FakeDev(t, map[string]string{
+		"disk/by-uuid/data-uuid": "../../sdb1",
+		"disk/by-label/data":     "../../sdb1",
+		"mapper/vg-srv":          "../dm-0",
+	}, "sda1")
+
+	if os.Getenv("HOST_DEV") != dev {
+		t.Errorf("HOST_DEV = %q, want %q", os.Getenv("HOST_DEV"), dev)
+	}
+	for link, want := range map[string]string{
+		"disk/by-uuid/data-uuid": "sdb1",
+		"disk/by-label/data":     "sdb1",
+		"mapper/vg-srv":          "dm-0",
+		"sda1":                   "sda1",
+	} {
+		got, err := filepath.EvalSymlinks(filepath.Join(dev, link))
+		if err != nil {
+			t.Errorf("%s: %v", link, err)
+			continue
+		}
+		if got != filepath.Join(dev, want) {
+			t.Errorf("%s resolves to %s, want %s", link, got, filepath.Join(dev, want))
+		}
+	}
+}
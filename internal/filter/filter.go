@@ -0,0 +1,187 @@
+// Package filter implements the mountpoint, filesystem type and device
+// filters shared by the disk checks and metrics commands.
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// Filter decides which partitions a command looks at. Each list holds
+// patterns as understood by Match. Ignore lists take precedence, and a
+// non-empty include list restricts partitions to the ones it matches. The
+// lists refine the named Profile: a partition has to pass both.
+type Filter struct {
+	Profile        string
+	IgnorePaths    []string
+	IncludePaths   []string
+	IgnoreTypes    []string
+	IncludeTypes   []string
+	IgnoreDevices  []string
+	IncludeDevices []string
+}
+
+// Validate returns an error for the first pattern of the filter that does
+// not compile
+func (f Filter) Validate() error {
+	if err := validateProfile(f.Profile); err != nil {
+		return err
+	}
+	return Validate(
+		f.IgnorePaths, f.IncludePaths,
+		f.IgnoreTypes, f.IncludeTypes,
+		f.IgnoreDevices, f.IncludeDevices,
+	)
+}
+
+// Validate returns an error for the first pattern in the lists that does not
+// compile
+func Validate(lists ...[]string) error {
+	for _, patterns := range lists {
+		for _, pattern := range patterns {
+			if !IsPattern(pattern) {
+				continue
+			}
+			if _, err := compile(pattern); err != nil {
+				return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Skip reports whether the partition is excluded by the filter
+func (f Filter) Skip(partition disk.PartitionStat) bool {
+	// Skip if the profile excludes the partition
+	if profiles[f.Profile].skip(partition) {
+		return true
+	}
+
+	// Skip if filesystem type should be ignored
+	if MatchAny(f.IgnoreTypes, partition.Fstype) {
+		return true
+	}
+
+	// Skip if not in include types (when include types is specified)
+	if len(f.IncludeTypes) > 0 && !MatchAny(f.IncludeTypes, partition.Fstype) {
+		return true
+	}
+
+	// Skip if mount point should be ignored
+	if MatchAny(f.IgnorePaths, partition.Mountpoint) {
+		return true
+	}
+
+	// Skip if not in include paths (when include paths is specified)
+	if len(f.IncludePaths) > 0 && !MatchAny(f.IncludePaths, partition.Mountpoint) {
+		return true
+	}
+
+	// Skip if device should be ignored
+	if MatchAny(f.IgnoreDevices, partition.Device) {
+		return true
+	}
+
+	// Skip if not in include devices (when include devices is specified)
+	if len(f.IncludeDevices) > 0 && !MatchAny(f.IncludeDevices, partition.Device) {
+		return true
+	}
+
+	return false
+}
+
+// AllPartitions reports whether the profile needs filesystems without a
+// backing device, such as network filesystems, to be listed
+func (f Filter) AllPartitions() bool {
+	return profiles[f.Profile].allPartitions
+}
+
+// MatchAny reports whether value matches any of the patterns
+func MatchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if Match(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether value matches pattern. A pattern starting with "~"
+// is a regular expression anchored at both ends. A pattern containing any of
+// the glob characters *, ? or [ is a glob in which * also matches across
+// path separators, so /var/lib/docker/* matches every mount below it. Any
+// other pattern must match exactly. Invalid patterns never match; commands
+// report them up front with Validate.
+func Match(pattern, value string) bool {
+	if !IsPattern(pattern) {
+		return pattern == value
+	}
+	re, err := compile(pattern)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(value)
+}
+
+// IsPattern reports whether pattern is a glob or regular expression rather
+// than a literal value
+func IsPattern(pattern string) bool {
+	return strings.HasPrefix(pattern, "~") || strings.ContainsAny(pattern, "*?[")
+}
+
+// compiled caches the result of compiling each pattern, so a pattern is
+// compiled once per run rather than for every value it is matched against
+var compiled sync.Map
+
+type compiledPattern struct {
+	re  *regexp.Regexp
+	err error
+}
+
+func compile(pattern string) (*regexp.Regexp, error) {
+	if c, ok := compiled.Load(pattern); ok {
+		return c.(compiledPattern).re, c.(compiledPattern).err
+	}
+
+	var c compiledPattern
+	if strings.HasPrefix(pattern, "~") {
+		c.re, c.err = regexp.Compile("^(?:" + pattern[1:] + ")$")
+	} else {
+		c.re, c.err = regexp.Compile("^" + globToRegexp(pattern) + "$")
+	}
+	compiled.Store(pattern, c)
+	return c.re, c.err
+}
+
+// globToRegexp translates a glob into an unanchored regular expression
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,132 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"/home", "/home", true},
+		{"/home", "/home/user", false},
+		{"/var/lib/docker/*", "/var/lib/docker/overlay2/abc/merged", true},
+		{"/var/lib/docker/*", "/var/lib/dockerd", false},
+		{"/snap/*", "/snap/core/1234", true},
+		{"/run/user/[0-9]*", "/run/user/1000", true},
+		{"/run/user/[!0-9]*", "/run/user/1000", false},
+		{"ext?", "ext4", true},
+		{`~/run/user/\d+`, "/run/user/1000", true},
+		{`~/run/user/\d+`, "/run/user/1000/doc", false},
+		{"~tmpfs|devtmpfs", "devtmpfs", true},
+		{"~tmpfs|devtmpfs", "devtmpfs2", false},
+		{"~(", "(", false},
+	}
+
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.value); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFilterSkip(t *testing.T) {
+	f := Filter{
+		IgnorePaths:    []string{"/var/lib/docker/*"},
+		IncludeTypes:   []string{"ext*", "xfs"},
+		IgnoreDevices:  []string{"/dev/loop*"},
+		IncludeDevices: nil,
+	}
+
+	tests := []struct {
+		partition disk.PartitionStat
+		want      bool
+	}{
+		{disk.PartitionStat{Device: "/dev/sda1", Mountpoint: "/", Fstype: "ext4"}, false},
+		{disk.PartitionStat{Device: "/dev/sda1", Mountpoint: "/var/lib/docker/volumes/x", Fstype: "ext4"}, true},
+		{disk.PartitionStat{Device: "/dev/sdb1", Mountpoint: "/data", Fstype: "btrfs"}, true},
+		{disk.PartitionStat{Device: "/dev/loop3", Mountpoint: "/mnt/image", Fstype: "ext2"}, true},
+	}
+
+	for _, tt := range tests {
+		if got := f.Skip(tt.partition); got != tt.want {
+			t.Errorf("Skip(%+v) = %v, want %v", tt.partition, got, tt.want)
+		}
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	if err := (Filter{IgnorePaths: []string{"~/run/user/("}}).Validate(); err == nil {
+		t.Error("expected error for invalid regular expression")
+	}
+	if err := (Filter{IgnorePaths: []string{"/snap/*", "~/run/user/\\d+"}}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCompileCached(t *testing.T) {
+	first, err := compile("~/data/[a-z]+")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := compile("~/data/[a-z]+"); again != first {
+		t.Error("expected a pattern to be compiled once")
+	}
+	if _, err := compile("~("); err == nil {
+		t.Error("expected the error of an invalid pattern to be kept")
+	}
+	if _, err := compile("~("); err == nil {
+		t.Error("expected the error of an invalid pattern to be kept")
+	}
+}
+
+func TestFilterProfiles(t *testing.T) {
+	root := disk.PartitionStat{Device: "/dev/sda1", Mountpoint: "/", Fstype: "ext4", Opts: []string{"rw", "relatime"}}
+	snap := disk.PartitionStat{Device: "/dev/loop4", Mountpoint: "/snap/core22/1234", Fstype: "squashfs", Opts: []string{"ro", "nodev"}}
+	shm := disk.PartitionStat{Device: "tmpfs", Mountpoint: "/dev/shm", Fstype: "tmpfs", Opts: []string{"rw"}}
+	nfs := disk.PartitionStat{Device: "nas:/export", Mountpoint: "/mnt/nas", Fstype: "nfs4", Opts: []string{"rw"}}
+	image := disk.PartitionStat{Device: "/dev/loop7", Mountpoint: "/mnt/image", Fstype: "ext4", Opts: []string{"rw"}}
+	recovery := disk.PartitionStat{Device: "/dev/sdb1", Mountpoint: "/recovery", Fstype: "ext4", Opts: []string{"ro"}}
+
+	tests := []struct {
+		profile string
+		kept    []disk.PartitionStat
+		skipped []disk.PartitionStat
+	}{
+		{ProfileAll, []disk.PartitionStat{root, snap, shm, nfs, image, recovery}, nil},
+		{ProfileLocal, []disk.PartitionStat{root, image, recovery}, []disk.PartitionStat{snap, shm, nfs}},
+		{ProfilePhysical, []disk.PartitionStat{root}, []disk.PartitionStat{snap, shm, nfs, image, recovery}},
+		{ProfileNetwork, []disk.PartitionStat{nfs}, []disk.PartitionStat{root, snap, shm, image, recovery}},
+	}
+
+	for _, tt := range tests {
+		f := Filter{Profile: tt.profile}
+		for _, partition := range tt.kept {
+			if f.Skip(partition) {
+				t.Errorf("profile %s skipped %s", tt.profile, partition.Mountpoint)
+			}
+		}
+		for _, partition := range tt.skipped {
+			if !f.Skip(partition) {
+				t.Errorf("profile %s kept %s", tt.profile, partition.Mountpoint)
+			}
+		}
+	}
+
+	// The other filters refine the profile
+	f := Filter{Profile: ProfileLocal, IgnorePaths: []string{"/mnt/*"}}
+	if !f.Skip(image) || f.Skip(root) {
+		t.Error("expected --ignore-paths to refine the local profile")
+	}
+
+	if !(Filter{Profile: ProfileNetwork}).AllPartitions() || (Filter{Profile: ProfileLocal}).AllPartitions() {
+		t.Error("expected only the network profile to list all partitions")
+	}
+	if err := (Filter{Profile: "remote"}).Validate(); err == nil {
+		t.Error("expected error for unknown profile")
+	}
+}
@@ -0,0 +1,116 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// Names of the built-in filter profiles
+const (
+	ProfileAll      = "all"
+	ProfileLocal    = "local"
+	ProfilePhysical = "physical"
+	ProfileNetwork  = "network"
+)
+
+// Profiles lists the names of the built-in filter profiles
+var Profiles = []string{ProfileAll, ProfileLocal, ProfilePhysical, ProfileNetwork}
+
+var (
+	// pseudoTypes are kernel and userspace filesystems that hold no data of
+	// their own, or only data in memory
+	pseudoTypes = []string{
+		"autofs", "binfmt_misc", "bpf", "cgroup", "cgroup2", "configfs", "debugfs",
+		"devfs", "devpts", "devtmpfs", "efivarfs", "fusectl", "hugetlbfs", "mqueue",
+		"nsfs", "proc", "pstore", "ramfs", "rootfs", "rpc_pipefs", "securityfs",
+		"selinuxfs", "sysfs", "tmpfs", "tracefs", "overlay", "aufs", "shm",
+		"fuse.lxcfs", "fuse.gvfsd-fuse", "fuse.portal", "fuse.snapfuse",
+	}
+
+	// readOnlyTypes can never fill up, e.g. snap's squashfs loop mounts are
+	// always 100% used
+	readOnlyTypes = []string{"squashfs", "iso9660", "udf", "erofs", "cramfs"}
+
+	networkTypes = []string{
+		"nfs", "nfs4", "cifs", "smb3", "smbfs", "ceph", "glusterfs", "lustre",
+		"gpfs", "beegfs", "afs", "9p", "davfs", "fuse.sshfs", "fuse.glusterfs",
+		"fuse.ceph-fuse", "fuse.s3fs", "fuse.rclone", "fuse.davfs",
+	}
+)
+
+// IsPseudo reports whether fstype is a kernel or in-memory filesystem that
+// is not backed by a disk or network share
+func IsPseudo(fstype string) bool {
+	return MatchAny(pseudoTypes, fstype)
+}
+
+// IsNetwork reports whether fstype is a network or cluster filesystem
+func IsNetwork(fstype string) bool {
+	return MatchAny(networkTypes, fstype)
+}
+
+// profile is a curated set of filesystem type, device and mount option
+// rules selecting a class of partitions
+type profile struct {
+	ignoreTypes   []string
+	includeTypes  []string
+	ignoreDevices []string
+	ignoreOptions []string
+	// allPartitions makes the commands list filesystems without a backing
+	// device too, which network filesystems are
+	allPartitions bool
+}
+
+var profiles = map[string]profile{
+	ProfileAll: {},
+	ProfileLocal: {
+		ignoreTypes: concat(pseudoTypes, readOnlyTypes, networkTypes),
+	},
+	ProfilePhysical: {
+		ignoreTypes:   concat(pseudoTypes, readOnlyTypes, networkTypes),
+		ignoreDevices: []string{"/dev/loop*", "/dev/ram*", "/dev/zram*"},
+		ignoreOptions: []string{"ro"},
+	},
+	ProfileNetwork: {
+		includeTypes:  networkTypes,
+		allPartitions: true,
+	},
+}
+
+func concat(lists ...[]string) []string {
+	var all []string
+	for _, list := range lists {
+		all = append(all, list...)
+	}
+	return all
+}
+
+// validateProfile returns an error if name is not a built-in profile. The
+// empty name is the same as all.
+func validateProfile(name string) error {
+	if _, ok := profiles[name]; !ok && name != "" {
+		return fmt.Errorf("unknown profile %q, must be one of %s", name, strings.Join(Profiles, ", "))
+	}
+	return nil
+}
+
+// skip reports whether the partition falls outside the profile
+func (p profile) skip(partition disk.PartitionStat) bool {
+	if MatchAny(p.ignoreTypes, partition.Fstype) {
+		return true
+	}
+	if len(p.includeTypes) > 0 && !MatchAny(p.includeTypes, partition.Fstype) {
+		return true
+	}
+	if MatchAny(p.ignoreDevices, partition.Device) {
+		return true
+	}
+	for _, option := range partition.Opts {
+		if MatchAny(p.ignoreOptions, option) {
+			return true
+		}
+	}
+	return false
+}
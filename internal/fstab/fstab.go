@@ -0,0 +1,194 @@
+// Package fstab parses fstab(5) files and resolves the device specs in them
+// to device nodes.
+package fstab
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Entry is one line of an fstab file. Device, MountPoint and FSType have
+// their octal escapes, such as \040 for a space, decoded.
+type Entry struct {
+	Device     string
+	MountPoint string
+	FSType     string
+	Options    string
+	Dump       int
+	Pass       int
+	// Line is the line number of the entry in the file
+	Line int
+}
+
+// ParseFile parses the fstab file at path
+func ParseFile(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// Parse reads fstab entries from r. Lines with fewer than four fields are
+// skipped. Like mount(8), Parse only needs the first four fields to be
+// valid: the dump and pass fields default to 0 when they are left out or
+// are not numbers, and any fields after them are ignored.
+func Parse(r io.Reader) ([]Entry, error) {
+	entries, _, err := parse(r, false)
+	return entries, err
+}
+
+// SyntaxError describes a line of an fstab file that is not a valid entry
+type SyntaxError struct {
+	Line   int
+	Reason string
+}
+
+func (e SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+// ParseStrict reads fstab entries from r like Parse, but skips every line
+// that is not a valid entry, including ones with more than six fields or a
+// non-numeric dump or pass field, and returns them as well
+func ParseStrict(r io.Reader) ([]Entry, []SyntaxError, error) {
+	return parse(r, true)
+}
+
+func parse(r io.Reader, strict bool) ([]Entry, []SyntaxError, error) {
+	var entries []Entry
+	var malformed []SyntaxError
+	scanner := bufio.NewScanner(r)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+
+		// Skip empty lines and comments
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		entry, reason := parseLine(text)
+		if reason != "" {
+			malformed = append(malformed, SyntaxError{Line: line, Reason: reason})
+			// Lines with four usable fields are still entries to mount(8)
+			if strict || entry.FSType == "" {
+				continue
+			}
+		}
+		entry.Line = line
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return entries, malformed, nil
+}
+
+// parseLine parses an fstab line, returning why it is not a valid entry if
+// it is not. The entry is returned along with the reason whenever the line
+// has at least four fields, with the dump and pass fields that are numbers.
+func parseLine(text string) (Entry, string) {
+	fields := strings.Fields(text)
+	if len(fields) < 4 {
+		return Entry{}, fmt.Sprintf("has %d fields, want 4 to 6", len(fields))
+	}
+
+	entry := Entry{
+		Device:     Unescape(fields[0]),
+		MountPoint: Unescape(fields[1]),
+		FSType:     Unescape(fields[2]),
+		Options:    fields[3],
+	}
+
+	var reason string
+	if len(fields) > 6 {
+		reason = fmt.Sprintf("has %d fields, want 4 to 6", len(fields))
+	}
+	if len(fields) > 4 {
+		if dump, err := strconv.Atoi(fields[4]); err == nil {
+			entry.Dump = dump
+		} else if reason == "" {
+			reason = fmt.Sprintf("dump field %q is not a number", fields[4])
+		}
+	}
+	if len(fields) > 5 {
+		if pass, err := strconv.Atoi(fields[5]); err == nil {
+			entry.Pass = pass
+		} else if reason == "" {
+			reason = fmt.Sprintf("pass field %q is not a number", fields[5])
+		}
+	}
+
+	return entry, reason
+}
+
+// Unescape decodes the three-digit octal escapes fstab and mountinfo use for
+// whitespace and backslashes in fields, e.g. /mnt/my\040disk
+func Unescape(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) && isOctal(field[i+1:i+4]) {
+			value, _ := strconv.ParseUint(field[i+1:i+4], 8, 8)
+			b.WriteByte(byte(value))
+			i += 3
+			continue
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
+
+func isOctal(digits string) bool {
+	for _, c := range digits {
+		if c < '0' || c > '7' {
+			return false
+		}
+	}
+	// \400 and above do not fit in a byte
+	return digits[0] <= '3'
+}
+
+// OptionList returns the comma-separated mount options of the entry
+func (e Entry) OptionList() []string {
+	if e.Options == "" {
+		return nil
+	}
+	options := strings.Split(e.Options, ",")
+	for i := range options {
+		options[i] = strings.TrimSpace(options[i])
+	}
+	return options
+}
+
+// Option returns the value of the named mount option and whether it is set.
+// Options without a value, such as noauto, have an empty value.
+func (e Entry) Option(name string) (string, bool) {
+	for _, option := range e.OptionList() {
+		key, value, _ := strings.Cut(option, "=")
+		if key == name {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// HasOption reports whether the named mount option is set
+func (e Entry) HasOption(name string) bool {
+	_, ok := e.Option(name)
+	return ok
+}
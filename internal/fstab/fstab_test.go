@@ -0,0 +1,136 @@
+package fstab
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nmollerup/sensu-check-disk/internal/devtest"
+)
+
+func TestParse(t *testing.T) {
+	data := `# /etc/fstab
+UUID=0a1b2c3d-0000-4000-8000-000000000001 /              ext4  errors=remount-ro 0 1
+LABEL=My\040Data                          /mnt/my\040data xfs   defaults,nofail   0 2
+server:/export                            /mnt/nfs       nfs4  _netdev
+/dev/sdb1 /broken
+/dev/sdc1 /srv ext4 defaults zero 2
+/dev/sdd1 /data xfs defaults 0 2 # data disk
+`
+
+	entries, err := Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Entry{
+		{Device: "UUID=0a1b2c3d-0000-4000-8000-000000000001", MountPoint: "/", FSType: "ext4", Options: "errors=remount-ro", Dump: 0, Pass: 1, Line: 2},
+		{Device: "LABEL=My Data", MountPoint: "/mnt/my data", FSType: "xfs", Options: "defaults,nofail", Dump: 0, Pass: 2, Line: 3},
+		{Device: "server:/export", MountPoint: "/mnt/nfs", FSType: "nfs4", Options: "_netdev", Line: 4},
+		{Device: "/dev/sdc1", MountPoint: "/srv", FSType: "ext4", Options: "defaults", Dump: 0, Pass: 2, Line: 6},
+		{Device: "/dev/sdd1", MountPoint: "/data", FSType: "xfs", Options: "defaults", Dump: 0, Pass: 2, Line: 7},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("Parse() returned %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	data := `/dev/sda1 / ext4 defaults 0 1
+/dev/sdb1 /broken
+/dev/sdc1 /srv ext4 defaults zero 2
+/dev/sdd1 /data xfs defaults 0 two
+/dev/sde1 /a /b ext4 defaults 0 2
+`
+
+	entries, malformed, err := ParseStrict(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].MountPoint != "/" {
+		t.Errorf("ParseStrict() entries = %+v, want only /", entries)
+	}
+
+	want := []string{
+		"line 2: has 2 fields, want 4 to 6",
+		`line 3: dump field "zero" is not a number`,
+		`line 4: pass field "two" is not a number`,
+		"line 5: has 7 fields, want 4 to 6",
+	}
+	if len(malformed) != len(want) {
+		t.Fatalf("ParseStrict() malformed = %v, want %v", malformed, want)
+	}
+	for i := range want {
+		if malformed[i].Error() != want[i] {
+			t.Errorf("malformed %d = %q, want %q", i, malformed[i].Error(), want[i])
+		}
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	tests := map[string]string{
+		`/mnt/my\040disk`: "/mnt/my disk",
+		`/mnt/tab\011x`:   "/mnt/tab\tx",
+		`/mnt/back\134x`:  `/mnt/back\x`,
+		`/mnt/plain`:      "/mnt/plain",
+		`/mnt/short\04`:   `/mnt/short\04`,
+		`/mnt/bad\089`:    `/mnt/bad\089`,
+	}
+	for in, want := range tests {
+		if got := Unescape(in); got != want {
+			t.Errorf("Unescape(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestOption(t *testing.T) {
+	entry := Entry{Options: "defaults,x-bind-foo,uid=1000,noauto"}
+	if !entry.HasOption("noauto") || entry.HasOption("bind") {
+		t.Error("HasOption should match whole option names only")
+	}
+	if value, ok := entry.Option("uid"); !ok || value != "1000" {
+		t.Errorf("Option(uid) = %q, %v", value, ok)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	devtest.FakeDev(t, map[string]string{
+		"disk/by-uuid/0a1b2c3d-0000-4000-8000-000000000001": "../../sda1",
+		"disk/by-uuid/ABCD-1234":                            "../../sdb1",
+		`disk/by-label/My\x20Data`:                          "../../sdb1",
+		"disk/by-partuuid/5e6f7a8b-01":                      "../../sda1",
+		"mapper/vg-data":                                    "../dm-0",
+	})
+
+	tests := map[string]string{
+		"UUID=0a1b2c3d-0000-4000-8000-000000000001":   "/dev/sda1",
+		`UUID="0A1B2C3D-0000-4000-8000-000000000001"`: "/dev/sda1",
+		"UUID=ABCD-1234":       "/dev/sdb1",
+		"LABEL=My Data":        "/dev/sdb1",
+		"PARTUUID=5e6f7a8b-01": "/dev/sda1",
+		"/dev/mapper/vg-data":  "/dev/dm-0",
+		"/dev/sda1":            "/dev/sda1",
+	}
+	for spec, want := range tests {
+		got, err := Resolve(spec)
+		if err != nil || got != want {
+			t.Errorf("Resolve(%s) = %q, %v, want %s", spec, got, err, want)
+		}
+	}
+
+	for _, spec := range []string{"UUID=deadbeef", "/dev/sdz9", "LABEL="} {
+		if _, err := Resolve(spec); err == nil || errors.Is(err, ErrNotDevice) {
+			t.Errorf("Resolve(%s) = %v, want a lookup error", spec, err)
+		}
+	}
+	for _, spec := range []string{"server:/export", "tmpfs", "tank/data", "SUBVOL=x"} {
+		if _, err := Resolve(spec); !errors.Is(err, ErrNotDevice) {
+			t.Errorf("Resolve(%s) = %v, want ErrNotDevice", spec, err)
+		}
+	}
+}
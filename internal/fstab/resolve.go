@@ -0,0 +1,93 @@
+package fstab
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nmollerup/sensu-check-disk/internal/hostroot"
+)
+
+// ErrNotDevice is returned by Resolve for specs that do not name a block
+// device, such as NFS exports, tmpfs or ZFS datasets
+var ErrNotDevice = errors.New("not a block device")
+
+// tagDirs maps the tags allowed in the device field to the udev directory
+// holding their symlinks
+var tagDirs = map[string]string{
+	"UUID":      "by-uuid",
+	"LABEL":     "by-label",
+	"PARTUUID":  "by-partuuid",
+	"PARTLABEL": "by-partlabel",
+}
+
+// Resolve returns the device node, such as /dev/sda1, that a device spec
+// from fstab or mountinfo refers to. UUID=, LABEL=, PARTUUID= and
+// PARTLABEL= specs are looked up in /dev/disk/by-*, and symlinks such as
+// /dev/mapper/vg-data are followed.
+func Resolve(spec string) (string, error) {
+	link, err := deviceLink(spec)
+	if err != nil {
+		return "", err
+	}
+
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("%s does not exist", spec)
+		}
+		return "", err
+	}
+
+	// Translate back from the host's /dev to /dev
+	rel, err := filepath.Rel(hostroot.Dev(), target)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%s resolves to %s outside /dev", spec, target)
+	}
+	return filepath.Join("/dev", rel), nil
+}
+
+func deviceLink(spec string) (string, error) {
+	if tag, value, ok := strings.Cut(spec, "="); ok {
+		dir, known := tagDirs[tag]
+		if !known {
+			return "", ErrNotDevice
+		}
+		value = strings.Trim(value, `"`)
+		if value == "" {
+			return "", fmt.Errorf("%s has an empty value", spec)
+		}
+		if tag == "UUID" || tag == "PARTUUID" {
+			// udev names the links in lower case except for short FAT
+			// serials such as ABCD-1234
+			if _, err := os.Lstat(hostroot.Dev("disk", dir, value)); err != nil {
+				value = strings.ToLower(value)
+			}
+		}
+		return hostroot.Dev("disk", dir, encodeTag(value)), nil
+	}
+
+	if !strings.HasPrefix(spec, "/dev/") {
+		return "", ErrNotDevice
+	}
+	return hostroot.Dev(strings.TrimPrefix(spec, "/dev/")), nil
+}
+
+// encodeTag escapes a tag value the way udev does for its by-label and
+// by-partlabel symlinks, e.g. "My Disk" becomes My\x20Disk
+func encodeTag(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z',
+			strings.IndexByte("#+-.:=@_", c) >= 0, c >= 0x80:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,105 @@
+// Package hostroot lets the commands inspect the host's filesystems when
+// they run in a container with the host root mounted at some directory,
+// e.g. with -v /:/host and --host-root /host.
+package hostroot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// root is the host root directory without trailing slash, or empty when the
+// commands look at their own mount namespace
+var root string
+
+// Set makes Partitions, Path and the Proc, Sys, Etc and Dev helpers use the host
+// filesystem mounted at dir. gopsutil is pointed at the host's /proc, /sys,
+// /etc and /dev through its HOST_* environment variables; values already
+// present in the environment take precedence. An empty dir or / is a no-op.
+func Set(dir string) {
+	dir = filepath.Clean(dir)
+	if dir == "." || dir == "/" {
+		root = ""
+		return
+	}
+	root = dir
+
+	for env, sub := range map[string]string{
+		"HOST_PROC": "proc",
+		"HOST_SYS":  "sys",
+		"HOST_ETC":  "etc",
+		"HOST_DEV":  "dev",
+	} {
+		if os.Getenv(env) == "" {
+			os.Setenv(env, filepath.Join(root, sub))
+		}
+	}
+}
+
+// Proc returns a path below the host's /proc, honouring HOST_PROC
+func Proc(elem ...string) string {
+	return hostPath("HOST_PROC", "/proc", elem)
+}
+
+// Sys returns a path below the host's /sys, honouring HOST_SYS
+func Sys(elem ...string) string {
+	return hostPath("HOST_SYS", "/sys", elem)
+}
+
+// Etc returns a path below the host's /etc, honouring HOST_ETC
+func Etc(elem ...string) string {
+	return hostPath("HOST_ETC", "/etc", elem)
+}
+
+// Dev returns a path below the host's /dev, honouring HOST_DEV
+func Dev(elem ...string) string {
+	return hostPath("HOST_DEV", "/dev", elem)
+}
+
+func hostPath(env, fallback string, elem []string) string {
+	base := os.Getenv(env)
+	if base == "" {
+		base = fallback
+	}
+	return filepath.Join(append([]string{base}, elem...)...)
+}
+
+// Path translates a host path, such as a mountpoint, into the path it is
+// reachable at from here
+func Path(path string) string {
+	if root == "" {
+		return path
+	}
+	return filepath.Join(root, path)
+}
+
+// Strip removes the host root prefix from path, turning a path seen from
+// here back into the host's view. Paths outside the host root are returned
+// unchanged.
+func Strip(path string) string {
+	if root == "" {
+		return path
+	}
+	if path == root {
+		return "/"
+	}
+	if strings.HasPrefix(path, root+"/") {
+		return path[len(root):]
+	}
+	return path
+}
+
+// Partitions returns disk.Partitions with mountpoints in the host's view
+func Partitions(all bool) ([]disk.PartitionStat, error) {
+	partitions, err := disk.Partitions(all)
+	if err != nil {
+		return nil, err
+	}
+	for i := range partitions {
+		partitions[i].Mountpoint = Strip(partitions[i].Mountpoint)
+	}
+	return partitions, nil
+}
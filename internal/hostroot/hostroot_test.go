@@ -0,0 +1,60 @@
+package hostroot
+
+import (
+	"testing"
+)
+
+func TestPathAndStrip(t *testing.T) {
+	t.Setenv("HOST_PROC", "")
+	t.Setenv("HOST_SYS", "/custom/sys")
+	t.Setenv("HOST_ETC", "")
+	t.Setenv("HOST_DEV", "")
+
+	Set("/host/")
+	defer Set("")
+
+	if got := Path("/data"); got != "/host/data" {
+		t.Errorf("Path(/data) = %s, want /host/data", got)
+	}
+	if got := Path("/"); got != "/host" {
+		t.Errorf("Path(/) = %s, want /host", got)
+	}
+
+	tests := map[string]string{
+		"/host":         "/",
+		"/host/data":    "/data",
+		"/hostdata":     "/hostdata",
+		"/var/lib/data": "/var/lib/data",
+	}
+	for path, want := range tests {
+		if got := Strip(path); got != want {
+			t.Errorf("Strip(%s) = %s, want %s", path, got, want)
+		}
+	}
+
+	if got := Proc("1", "mountinfo"); got != "/host/proc/1/mountinfo" {
+		t.Errorf("Proc(1, mountinfo) = %s, want /host/proc/1/mountinfo", got)
+	}
+	if got := Etc("fstab"); got != "/host/etc/fstab" {
+		t.Errorf("Etc(fstab) = %s, want /host/etc/fstab", got)
+	}
+	// Variables already in the environment win over --host-root
+	if got := Sys("fs", "btrfs"); got != "/custom/sys/fs/btrfs" {
+		t.Errorf("Sys(fs, btrfs) = %s, want /custom/sys/fs/btrfs", got)
+	}
+}
+
+func TestUnset(t *testing.T) {
+	t.Setenv("HOST_PROC", "")
+
+	Set("/")
+	if got := Path("/data"); got != "/data" {
+		t.Errorf("Path(/data) = %s, want /data", got)
+	}
+	if got := Strip("/data"); got != "/data" {
+		t.Errorf("Strip(/data) = %s, want /data", got)
+	}
+	if got := Proc("self", "mountinfo"); got != "/proc/self/mountinfo" {
+		t.Errorf("Proc(self, mountinfo) = %s, want /proc/self/mountinfo", got)
+	}
+}
@@ -0,0 +1,226 @@
+// Package kubelet recognises the mountpoints kubelet creates for pod volumes,
+// such as /var/lib/kubelet/pods/<uid>/volumes/kubernetes.io~csi/<pv>/mount,
+// and labels them with the pod UID, volume name and persistent volume name.
+package kubelet
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/nmollerup/sensu-check-disk/internal/filter"
+	"github.com/nmollerup/sensu-check-disk/internal/hostroot"
+)
+
+// Label names understood by Volume.Labels and Filter
+const (
+	LabelPodUID = "pod_uid"
+	LabelVolume = "volume"
+	LabelPlugin = "plugin"
+	LabelPV     = "pv"
+)
+
+// Labels lists the label names a volume can have
+var Labels = []string{LabelPodUID, LabelVolume, LabelPlugin, LabelPV}
+
+var (
+	// The kubelet root directory is configurable (e.g. /var/lib/k0s/kubelet),
+	// so only the part below it is matched
+	podVolume  = regexp.MustCompile(`/pods/([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})/volumes/([^/]+)/([^/]+)(/mount)?$`)
+	podSubpath = regexp.MustCompile(`/pods/([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})/volume-subpaths/([^/]+)/[^/]+/[0-9]+$`)
+	csiGlobal  = regexp.MustCompile(`/plugins/kubernetes\.io/csi/pv/([^/]+)/globalmount$`)
+	// Since Kubernetes 1.24 the staging directory is named after the driver
+	// and a SHA-256 of the volume handle instead of the PV
+	csiStaging = regexp.MustCompile(`/plugins/kubernetes\.io/csi/[^/]+/[0-9a-f]{64}/globalmount$`)
+)
+
+// Volume describes a kubelet volume mount. Fields that cannot be told from
+// the mountpoint are empty.
+type Volume struct {
+	PodUID string
+	// Name is the name of the volume directory, which is the PV name for
+	// volumes backed by a persistent volume and the pod's volume name
+	// otherwise
+	Name string
+	// Plugin is the volume plugin without the kubernetes.io~ prefix, e.g.
+	// csi, empty-dir or secret
+	Plugin string
+	PV     string
+}
+
+// volData is the part of the vol_data.json file kubelet writes next to CSI
+// volume mounts that is needed to tell persistent from ephemeral volumes
+type volData struct {
+	SpecVolID     string `json:"specVolID"`
+	LifecycleMode string `json:"volumeLifecycleMode"`
+}
+
+// Parse returns the volume mounted at mountpoint, or false if mountpoint is
+// not a kubelet volume mount. For CSI volumes the PV name is read from
+// kubelet's vol_data.json; other volumes are taken to be PV-backed when
+// their name has the pvc- prefix of dynamically provisioned volumes. CSI
+// global mounts in the per-driver layout of Kubernetes 1.24 and later are
+// only recognised when their vol_data.json names the PV, which kubelet
+// versions that do not record it there leave out.
+func Parse(mountpoint string) (Volume, bool) {
+	if m := podVolume.FindStringSubmatch(mountpoint); m != nil {
+		volume := Volume{
+			PodUID: m[1],
+			Plugin: strings.TrimPrefix(m[2], "kubernetes.io~"),
+			Name:   m[3],
+		}
+		if strings.HasPrefix(volume.Name, "pvc-") {
+			volume.PV = volume.Name
+		}
+		if volume.Plugin == "csi" && m[4] != "" {
+			if data, ok := readVolData(filepath.Dir(mountpoint)); ok {
+				volume.PV = ""
+				if data.LifecycleMode != "Ephemeral" {
+					volume.PV = data.SpecVolID
+				}
+			}
+		}
+		return volume, true
+	}
+	if m := podSubpath.FindStringSubmatch(mountpoint); m != nil {
+		return Volume{PodUID: m[1], Name: m[2]}, true
+	}
+	if m := csiGlobal.FindStringSubmatch(mountpoint); m != nil {
+		return Volume{Name: m[1], Plugin: "csi", PV: m[1]}, true
+	}
+	if csiStaging.MatchString(mountpoint) {
+		if data, ok := readVolData(filepath.Dir(mountpoint)); ok && data.SpecVolID != "" {
+			return Volume{Name: data.SpecVolID, Plugin: "csi", PV: data.SpecVolID}, true
+		}
+	}
+	return Volume{}, false
+}
+
+func readVolData(dir string) (volData, bool) {
+	raw, err := os.ReadFile(hostroot.Path(filepath.Join(dir, "vol_data.json")))
+	if err != nil {
+		return volData{}, false
+	}
+	var data volData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return volData{}, false
+	}
+	return data, true
+}
+
+// Labels returns the non-empty labels of the volume
+func (v Volume) Labels() map[string]string {
+	labels := make(map[string]string)
+	for name, value := range map[string]string{
+		LabelPodUID: v.PodUID,
+		LabelVolume: v.Name,
+		LabelPlugin: v.Plugin,
+		LabelPV:     v.PV,
+	} {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	return labels
+}
+
+// String returns a readable name for the volume for use in check output
+func (v Volume) String() string {
+	switch {
+	case v.PV != "" && v.PodUID != "":
+		return fmt.Sprintf("pv %s in pod %s", v.PV, v.PodUID)
+	case v.PV != "":
+		return fmt.Sprintf("pv %s", v.PV)
+	}
+	return fmt.Sprintf("volume %s in pod %s", v.Name, v.PodUID)
+}
+
+// MetricPath returns a Graphite path for the volume. PV-backed volumes are
+// named after the PV so their metrics survive the pod being replaced. All
+// mounts of a PV on a node, such as its global mount and the mounts of the
+// pods sharing a ReadWriteMany volume, therefore have the same path, and
+// callers write the metrics of only one of them.
+func (v Volume) MetricPath() string {
+	node := func(s string) string {
+		return strings.ReplaceAll(s, ".", "_")
+	}
+	if v.PV != "" {
+		return "kubernetes.pv." + node(v.PV)
+	}
+	return "kubernetes.pod." + node(v.PodUID) + "." + node(v.Name)
+}
+
+// Filter selects kubelet volumes by their labels. Each entry has the form
+// label=pattern, where pattern is understood by filter.Match. Mountpoints
+// that are not kubelet volumes are never skipped, and a volume without a
+// label matches no pattern for it.
+type Filter struct {
+	IgnoreLabels  []string
+	IncludeLabels []string
+}
+
+// Validate returns an error for the first entry that is not label=pattern
+// with a known label and a valid pattern
+func (f Filter) Validate() error {
+	for _, entries := range [][]string{f.IgnoreLabels, f.IncludeLabels} {
+		for _, entry := range entries {
+			name, pattern, ok := strings.Cut(entry, "=")
+			if !ok {
+				return fmt.Errorf("invalid label filter %q: must be label=pattern", entry)
+			}
+			if !knownLabel(name) {
+				return fmt.Errorf("invalid label filter %q: label must be one of %s", entry, strings.Join(Labels, ", "))
+			}
+			if err := filter.Validate([]string{pattern}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Skip reports whether the mountpoint is a kubelet volume excluded by the
+// filter
+func (f Filter) Skip(mountpoint string) bool {
+	if len(f.IgnoreLabels) == 0 && len(f.IncludeLabels) == 0 {
+		return false
+	}
+	volume, ok := Parse(mountpoint)
+	if !ok {
+		return false
+	}
+	labels := volume.Labels()
+	if matchLabels(f.IgnoreLabels, labels) {
+		return true
+	}
+	return len(f.IncludeLabels) > 0 && !matchLabels(f.IncludeLabels, labels)
+}
+
+// MatchLabel reports whether the label is present in labels and matches
+// pattern
+func MatchLabel(name, pattern string, labels map[string]string) bool {
+	value, ok := labels[name]
+	return ok && filter.Match(pattern, value)
+}
+
+func matchLabels(entries []string, labels map[string]string) bool {
+	for _, entry := range entries {
+		name, pattern, _ := strings.Cut(entry, "=")
+		if MatchLabel(name, pattern, labels) {
+			return true
+		}
+	}
+	return false
+}
+
+func knownLabel(name string) bool {
+	for _, label := range Labels {
+		if label == name {
+			return true
+		}
+	}
+	return false
+}
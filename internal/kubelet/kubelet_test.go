@@ -0,0 +1,125 @@
+package kubelet
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const podUID = "0f4c8a5e-6b1d-4c3e-9a2f-1d2e3f4a5b6c"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		mountpoint string
+		want       Volume
+		ok         bool
+	}{
+		{"/var/lib/kubelet/pods/" + podUID + "/volumes/kubernetes.io~csi/pvc-1234/mount",
+			Volume{PodUID: podUID, Name: "pvc-1234", Plugin: "csi", PV: "pvc-1234"}, true},
+		{"/var/lib/k0s/kubelet/pods/" + podUID + "/volumes/kubernetes.io~empty-dir/cache",
+			Volume{PodUID: podUID, Name: "cache", Plugin: "empty-dir"}, true},
+		{"/var/lib/kubelet/pods/" + podUID + "/volumes/kubernetes.io~aws-ebs/pvc-5678",
+			Volume{PodUID: podUID, Name: "pvc-5678", Plugin: "aws-ebs", PV: "pvc-5678"}, true},
+		{"/var/lib/kubelet/pods/" + podUID + "/volume-subpaths/config/nginx/0",
+			Volume{PodUID: podUID, Name: "config"}, true},
+		{"/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-1234/globalmount",
+			Volume{Name: "pvc-1234", Plugin: "csi", PV: "pvc-1234"}, true},
+		{"/var/lib/kubelet", Volume{}, false},
+		{"/var/lib/kubelet/pods/not-a-uid/volumes/kubernetes.io~csi/pvc-1234/mount", Volume{}, false},
+		{"/", Volume{}, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := Parse(tt.mountpoint)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("Parse(%s) = %+v, %v, want %+v, %v", tt.mountpoint, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseVolData(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, data string) string {
+		dir := filepath.Join(root, "pods", podUID, "volumes", "kubernetes.io~csi", name)
+		if err := os.MkdirAll(filepath.Join(dir, "mount"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "vol_data.json"), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return filepath.Join(dir, "mount")
+	}
+
+	persistent := write("data-volume", `{"specVolID":"data-volume","volumeLifecycleMode":"Persistent"}`)
+	if got, _ := Parse(persistent); got.PV != "data-volume" {
+		t.Errorf("persistent volume PV = %q, want data-volume", got.PV)
+	}
+
+	staging := filepath.Join(root, "plugins", "kubernetes.io", "csi", "ebs.csi.aws.com", strings.Repeat("ab", 32))
+	if err := os.MkdirAll(filepath.Join(staging, "globalmount"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Parse(filepath.Join(staging, "globalmount")); ok {
+		t.Error("expected a staging mount without vol_data.json not to be recognised")
+	}
+	if err := os.WriteFile(filepath.Join(staging, "vol_data.json"), []byte(`{"specVolID":"pvc-1234","volumeHandle":"vol-0abc","driverName":"ebs.csi.aws.com"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := Parse(filepath.Join(staging, "globalmount")); !ok || got != (Volume{Name: "pvc-1234", Plugin: "csi", PV: "pvc-1234"}) {
+		t.Errorf("staging mount = %+v, %v, want pv pvc-1234", got, ok)
+	}
+
+	ephemeral := write("pvc-scratch", `{"specVolID":"pvc-scratch","volumeLifecycleMode":"Ephemeral"}`)
+	if got, _ := Parse(ephemeral); got.PV != "" {
+		t.Errorf("ephemeral volume PV = %q, want none", got.PV)
+	}
+}
+
+func TestVolumeNames(t *testing.T) {
+	pv := Volume{PodUID: podUID, Name: "pvc-1.2", Plugin: "csi", PV: "pvc-1.2"}
+	if got := pv.String(); got != "pv pvc-1.2 in pod "+podUID {
+		t.Errorf("String() = %s", got)
+	}
+	if got := pv.MetricPath(); got != "kubernetes.pv.pvc-1_2" {
+		t.Errorf("MetricPath() = %s", got)
+	}
+
+	scratch := Volume{PodUID: podUID, Name: "cache", Plugin: "empty-dir"}
+	if got := scratch.String(); got != "volume cache in pod "+podUID {
+		t.Errorf("String() = %s", got)
+	}
+	if got := scratch.MetricPath(); got != "kubernetes.pod."+podUID+".cache" {
+		t.Errorf("MetricPath() = %s", got)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	pvMount := "/var/lib/kubelet/pods/" + podUID + "/volumes/kubernetes.io~csi/pvc-1234/mount"
+	emptyDir := "/var/lib/kubelet/pods/" + podUID + "/volumes/kubernetes.io~empty-dir/cache"
+
+	onlyPVs := Filter{IncludeLabels: []string{"pv=*"}}
+	if onlyPVs.Skip(pvMount) {
+		t.Error("pv=* should include PV-backed volumes")
+	}
+	if !onlyPVs.Skip(emptyDir) {
+		t.Error("pv=* should skip volumes without a PV")
+	}
+	if onlyPVs.Skip("/home") {
+		t.Error("label filters should not skip mounts that are not kubelet volumes")
+	}
+
+	ignore := Filter{IgnoreLabels: []string{"plugin=empty-dir"}}
+	if !ignore.Skip(emptyDir) || ignore.Skip(pvMount) {
+		t.Error("plugin=empty-dir should skip only the empty-dir volume")
+	}
+
+	for _, entries := range [][]string{{"pv"}, {"node=worker-1"}, {"pv=~["}} {
+		if err := (Filter{IncludeLabels: entries}).Validate(); err == nil {
+			t.Errorf("Validate(%v) should fail", entries)
+		}
+	}
+	if err := onlyPVs.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+}
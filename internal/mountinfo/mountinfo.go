@@ -0,0 +1,220 @@
+// Package mountinfo parses /proc/<pid>/mountinfo, which unlike /proc/mounts
+// tells mounts apart by ID and shows the root of each mount within its
+// filesystem, its device number and its propagation.
+package mountinfo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nmollerup/sensu-check-disk/internal/fstab"
+	"github.com/nmollerup/sensu-check-disk/internal/hostroot"
+)
+
+// Mount is one line of a mountinfo file
+type Mount struct {
+	ID     int
+	Parent int
+	Major  int
+	Minor  int
+	// Root is the directory of the filesystem mounted at Mountpoint, which
+	// is / unless this is a bind mount of a subdirectory or a btrfs subvolume
+	Root       string
+	Mountpoint string
+	// Options are the per-mount options, such as ro, nosuid and relatime
+	Options []string
+	// Shared is the peer group of a shared mount, Master the peer group a
+	// slave mount receives propagation from and PropagateFrom the closest
+	// dominant peer group of a slave; each is 0 when not applicable
+	Shared        int
+	Master        int
+	PropagateFrom int
+	Unbindable    bool
+	FSType        string
+	Source        string
+	// SuperOptions are the options of the superblock, shared by every mount
+	// of the same filesystem
+	SuperOptions []string
+}
+
+// Read returns the mounts of the host's init process, falling back to the
+// current process, with mountpoints in the host's view
+func Read() ([]Mount, error) {
+	mounts, err := ReadFile(hostroot.Proc("1", "mountinfo"))
+	if err != nil {
+		mounts, err = ReadFile(hostroot.Proc("self", "mountinfo"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := range mounts {
+		mounts[i].Mountpoint = hostroot.Strip(mounts[i].Mountpoint)
+	}
+	return mounts, nil
+}
+
+// ReadFile parses the mountinfo file at path
+func ReadFile(path string) ([]Mount, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// Parse reads mountinfo lines from r
+func Parse(r io.Reader) ([]Mount, error) {
+	var mounts []Mount
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		mount, err := parseLine(line)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, mount)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// parseLine parses a line such as
+//
+//	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+//
+// where the optional fields before the hyphen may be absent or repeated
+func parseLine(line string) (Mount, error) {
+	fields := strings.Fields(line)
+	separator := -1
+	for i := 6; i < len(fields); i++ {
+		if fields[i] == "-" {
+			separator = i
+			break
+		}
+	}
+	if separator < 0 || len(fields) < separator+4 {
+		return Mount{}, fmt.Errorf("invalid mountinfo line: %s", line)
+	}
+
+	var m Mount
+	var err error
+	if m.ID, err = strconv.Atoi(fields[0]); err != nil {
+		return Mount{}, fmt.Errorf("invalid mount ID in mountinfo line: %s", line)
+	}
+	if m.Parent, err = strconv.Atoi(fields[1]); err != nil {
+		return Mount{}, fmt.Errorf("invalid parent ID in mountinfo line: %s", line)
+	}
+	major, minor, ok := strings.Cut(fields[2], ":")
+	if !ok {
+		return Mount{}, fmt.Errorf("invalid device number in mountinfo line: %s", line)
+	}
+	if m.Major, err = strconv.Atoi(major); err != nil {
+		return Mount{}, fmt.Errorf("invalid device number in mountinfo line: %s", line)
+	}
+	if m.Minor, err = strconv.Atoi(minor); err != nil {
+		return Mount{}, fmt.Errorf("invalid device number in mountinfo line: %s", line)
+	}
+
+	m.Root = fstab.Unescape(fields[3])
+	m.Mountpoint = fstab.Unescape(fields[4])
+	m.Options = strings.Split(fields[5], ",")
+
+	for _, field := range fields[6:separator] {
+		tag, value, _ := strings.Cut(field, ":")
+		switch tag {
+		case "shared":
+			m.Shared, _ = strconv.Atoi(value)
+		case "master":
+			m.Master, _ = strconv.Atoi(value)
+		case "propagate_from":
+			m.PropagateFrom, _ = strconv.Atoi(value)
+		case "unbindable":
+			m.Unbindable = true
+		}
+	}
+
+	m.FSType = fstab.Unescape(fields[separator+1])
+	m.Source = fstab.Unescape(fields[separator+2])
+	m.SuperOptions = strings.Split(fields[separator+3], ",")
+
+	return m, nil
+}
+
+// Device returns the source of the mount, replacing /dev/root, which the
+// kernel reports for a root filesystem given on the kernel command line, by
+// the device node with the mount's device number
+func (m Mount) Device() string {
+	if m.Source != "/dev/root" {
+		return m.Source
+	}
+	target, err := os.Readlink(hostroot.Sys("dev", "block", fmt.Sprintf("%d:%d", m.Major, m.Minor)))
+	if err != nil {
+		return m.Source
+	}
+	return "/dev/" + filepath.Base(target)
+}
+
+// HasOption reports whether the per-mount or superblock options contain
+// the named option
+func (m Mount) HasOption(name string) bool {
+	for _, options := range [][]string{m.Options, m.SuperOptions} {
+		for _, option := range options {
+			if key, _, _ := strings.Cut(option, "="); key == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Visible returns the topmost mount at each mountpoint, keyed by mountpoint.
+// A mount that is mounted over hides the ones below it.
+func Visible(mounts []Mount) map[string]Mount {
+	// A mount stacked on another one has it as parent
+	coveredBy := make(map[int]bool)
+	mountpoints := make(map[int]string)
+	for _, mount := range mounts {
+		mountpoints[mount.ID] = mount.Mountpoint
+	}
+	for _, mount := range mounts {
+		if mountpoints[mount.Parent] == mount.Mountpoint {
+			coveredBy[mount.Parent] = true
+		}
+	}
+
+	visible := make(map[string]Mount)
+	for _, mount := range mounts {
+		if !coveredBy[mount.ID] {
+			visible[mount.Mountpoint] = mount
+		}
+	}
+	return visible
+}
+
+// Containing returns the visible mount holding path, which is the one with
+// the longest mountpoint that is a parent of path, and the path relative to
+// that mountpoint
+func Containing(visible map[string]Mount, path string) (Mount, string, bool) {
+	path = filepath.Clean(path)
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if mount, ok := visible[dir]; ok {
+			rel, _ := filepath.Rel(dir, path)
+			return mount, rel, true
+		}
+		if dir == "/" || dir == "." {
+			return Mount{}, "", false
+		}
+	}
+}
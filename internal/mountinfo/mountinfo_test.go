@@ -0,0 +1,145 @@
+package mountinfo
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sample = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/root rw,errors=remount-ro
+23 22 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+24 22 8:17 / /data rw,noatime shared:2 - xfs /dev/sdb1 rw,attr2,inode64
+25 22 8:17 /www /srv/my\040www ro,noatime shared:2 - xfs /dev/sdb1 rw,attr2,inode64
+26 22 0:40 / /mnt/nas rw,relatime master:5 propagate_from:3 - nfs4 nas:/export rw,vers=4.2
+27 22 0:41 / /tmp rw,nosuid,nodev unbindable - tmpfs tmpfs rw,size=1024k
+28 27 0:42 / /tmp rw,nosuid,nodev - tmpfs tmpfs rw,size=2048k
+`
+
+func TestParse(t *testing.T) {
+	mounts, err := Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 7 {
+		t.Fatalf("Parse() returned %d mounts, want 7: %+v", len(mounts), mounts)
+	}
+
+	want := Mount{
+		ID: 25, Parent: 22, Major: 8, Minor: 17,
+		Root: "/www", Mountpoint: "/srv/my www",
+		Options: []string{"ro", "noatime"}, Shared: 2,
+		FSType: "xfs", Source: "/dev/sdb1",
+		SuperOptions: []string{"rw", "attr2", "inode64"},
+	}
+	if !reflect.DeepEqual(mounts[3], want) {
+		t.Errorf("mount 3 = %+v, want %+v", mounts[3], want)
+	}
+
+	nfs := mounts[4]
+	if nfs.Shared != 0 || nfs.Master != 5 || nfs.PropagateFrom != 3 || nfs.Source != "nas:/export" {
+		t.Errorf("unexpected propagation or source: %+v", nfs)
+	}
+	if !mounts[5].Unbindable {
+		t.Errorf("expected mount 5 to be unbindable: %+v", mounts[5])
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, line := range []string{
+		"22 1 8:1 / / rw,relatime shared:1 ext4 /dev/root rw",
+		"22 1 8:1 / / rw - ext4",
+		"x 1 8:1 / / rw - ext4 /dev/root rw",
+		"22 1 8 / / rw - ext4 /dev/root rw",
+	} {
+		if _, err := Parse(strings.NewReader(line)); err == nil {
+			t.Errorf("expected error for %q", line)
+		}
+	}
+}
+
+func TestHasOption(t *testing.T) {
+	mounts, err := Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nfs := mounts[4]
+	if !nfs.HasOption("relatime") || !nfs.HasOption("vers") || nfs.HasOption("ro") {
+		t.Errorf("unexpected options: %+v", nfs)
+	}
+}
+
+func TestVisible(t *testing.T) {
+	mounts, err := Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	visible := Visible(mounts)
+	if len(visible) != 6 {
+		t.Errorf("Visible() returned %d mountpoints, want 6", len(visible))
+	}
+	if visible["/tmp"].ID != 28 {
+		t.Errorf("expected the tmpfs mounted on top at /tmp, got %+v", visible["/tmp"])
+	}
+
+	// A mount listed before the one it is stacked on still hides it
+	mounts[5], mounts[6] = mounts[6], mounts[5]
+	if got := Visible(mounts)["/tmp"].ID; got != 28 {
+		t.Errorf("expected mount 28 at /tmp regardless of order, got %d", got)
+	}
+}
+
+func TestContaining(t *testing.T) {
+	mounts, err := Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	visible := Visible(mounts)
+
+	tests := []struct {
+		path string
+		id   int
+		rel  string
+	}{
+		{"/data/www/", 24, "www"},
+		{"/data", 24, "."},
+		{"/srv/my www/html", 25, "html"},
+		{"/etc/fstab", 22, "etc/fstab"},
+	}
+	for _, tt := range tests {
+		mount, rel, ok := Containing(visible, tt.path)
+		if !ok || mount.ID != tt.id || rel != tt.rel {
+			t.Errorf("Containing(%q) = %d, %q, %v, want %d, %q", tt.path, mount.ID, rel, ok, tt.id, tt.rel)
+		}
+	}
+
+	if _, _, ok := Containing(map[string]Mount{}, "/data"); ok {
+		t.Error("expected no mount without a root mount")
+	}
+}
+
+func TestDevice(t *testing.T) {
+	sys := t.TempDir()
+	t.Setenv("HOST_SYS", sys)
+	if err := os.MkdirAll(filepath.Join(sys, "dev", "block"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../devices/pci0000:00/block/sda/sda1", filepath.Join(sys, "dev", "block", "8:1")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		mount Mount
+		want  string
+	}{
+		{Mount{Major: 8, Minor: 1, Source: "/dev/root"}, "/dev/sda1"},
+		{Mount{Major: 8, Minor: 2, Source: "/dev/root"}, "/dev/root"},
+		{Mount{Major: 8, Minor: 17, Source: "/dev/sdb1"}, "/dev/sdb1"},
+	}
+	for _, tt := range tests {
+		if got := tt.mount.Device(); got != tt.want {
+			t.Errorf("Device() of %+v = %q, want %q", tt.mount, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Package statefile keeps the JSON state the commands carry from one run to
+// the next, such as usage samples and swap counters.
+package statefile
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultPath returns the state file used by command when no state file is
+// given. The file lives in the user's cache directory rather than the shared
+// /tmp, and its name is derived from the option values in keys, so check
+// definitions that must not share state, such as ones with different filters
+// or host roots, get separate files. The directory is created if needed.
+func DefaultPath(command string, keys ...string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "sensu-check-disk")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(keys, "\x00")))
+	return filepath.Join(dir, fmt.Sprintf("%s-%016x.json", command, h.Sum64())), nil
+}
+
+// Load decodes the state file at path into v. It reports false when there is
+// no state yet or the file cannot be decoded; a corrupt state file only costs
+// the history, so callers start over rather than fail.
+func Load(path string, v interface{}) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+// Save writes v to the state file at path as JSON
+func Save(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename so a concurrent run never reads
+	// a partially written state
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,75 @@
+package statefile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	var got map[string][]int
+	if Load(path, &got) {
+		t.Error("expected no state before the first save")
+	}
+
+	want := map[string][]int{"/data": {1, 2, 3}}
+	if err := Save(path, want); err != nil {
+		t.Fatal(err)
+	}
+	if !Load(path, &got) || !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() = %v, want %v", got, want)
+	}
+
+	// The temporary file is renamed into place
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the state file to be left, got %v", entries)
+	}
+}
+
+func TestLoad_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var state map[string]int
+	if Load(path, &state) {
+		t.Error("expected a corrupt state file not to load")
+	}
+}
+
+func TestSave_MissingDir(t *testing.T) {
+	if err := Save(filepath.Join(t.TempDir(), "missing", "state.json"), 1); err == nil {
+		t.Error("expected error for a state file in a missing directory")
+	}
+}
+
+func TestDefaultPath(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+
+	local, err := DefaultPath("check-swap", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, err := DefaultPath("check-swap", "/host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local == host {
+		t.Errorf("expected different keys to use different state files, got %s", local)
+	}
+	if filepath.Dir(local) != filepath.Join(cache, "sensu-check-disk") {
+		t.Errorf("expected the state file in the user cache directory, got %s", local)
+	}
+	if _, err := os.Stat(filepath.Dir(local)); err != nil {
+		t.Errorf("expected the state directory to be created: %v", err)
+	}
+}
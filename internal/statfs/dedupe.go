@@ -0,0 +1,112 @@
+package statfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nmollerup/sensu-check-disk/internal/btrfs"
+	"github.com/nmollerup/sensu-check-disk/internal/hostroot"
+)
+
+// btrfsUUIDFunc is replaced in tests
+var btrfsUUIDFunc = btrfs.UUID
+
+// Ways of grouping results accepted by Dedupe
+const (
+	DedupeNone       = "none"
+	DedupeDevice     = "device"
+	DedupeFilesystem = "filesystem"
+)
+
+// DedupeModes lists the values accepted by Dedupe
+var DedupeModes = []string{DedupeNone, DedupeDevice, DedupeFilesystem}
+
+// Dedupe merges results that refer to the same filesystem, such as bind
+// mounts and repeated mounts of one device, into a single result. Results
+// are grouped by partition device node (DedupeDevice), falling back to the
+// filesystem ID for sources that are not under /dev, or by filesystem ID
+// (DedupeFilesystem). Every btrfs subvolume mount has its own anonymous
+// filesystem ID, so DedupeFilesystem groups btrfs by filesystem UUID. The
+// canonical mountpoint of a group is the one that is not a bind mount, then
+// the shortest, and the others are listed in Aliases. Failed lookups are
+// never merged. Order follows the first member of each group.
+func Dedupe(results []Result, by string) []Result {
+	if by == "" || by == DedupeNone {
+		return results
+	}
+
+	var merged []Result
+	index := make(map[string]int)
+	for _, result := range results {
+		key, ok := dedupeKey(result, by)
+		if !ok {
+			merged = append(merged, result)
+			continue
+		}
+
+		i, seen := index[key]
+		if !seen {
+			index[key] = len(merged)
+			merged = append(merged, result)
+			continue
+		}
+
+		group := &merged[i]
+		if preferred(result, *group) {
+			result.Aliases = append(group.Aliases, group.Partition.Mountpoint)
+			*group = result
+		} else {
+			group.Aliases = append(group.Aliases, result.Partition.Mountpoint)
+		}
+	}
+
+	for i := range merged {
+		sort.Strings(merged[i].Aliases)
+	}
+	return merged
+}
+
+func dedupeKey(result Result, by string) (string, bool) {
+	if result.Err != nil {
+		return "", false
+	}
+	switch by {
+	case DedupeDevice:
+		// Sources such as tmpfs, overlay or an NFS export name no device
+		// and are shared by unrelated filesystems, so only device nodes
+		// are grouped by name
+		if strings.HasPrefix(result.Partition.Device, "/dev/") {
+			return result.Partition.Device, true
+		}
+		return fmt.Sprint(result.DeviceID), result.DeviceID != 0
+	case DedupeFilesystem:
+		if result.Partition.Fstype == "btrfs" {
+			if uuid, err := btrfsUUIDFunc(hostroot.Sys(), result.Partition.Device); err == nil {
+				return "btrfs:" + uuid, true
+			}
+		}
+		return fmt.Sprint(result.DeviceID), result.DeviceID != 0
+	}
+	return "", false
+}
+
+// preferred reports whether a makes a better canonical mountpoint than b
+func preferred(a, b Result) bool {
+	if aBind, bBind := isBind(a), isBind(b); aBind != bBind {
+		return !aBind
+	}
+	if len(a.Partition.Mountpoint) != len(b.Partition.Mountpoint) {
+		return len(a.Partition.Mountpoint) < len(b.Partition.Mountpoint)
+	}
+	return a.Partition.Mountpoint < b.Partition.Mountpoint
+}
+
+func isBind(result Result) bool {
+	for _, opt := range result.Partition.Opts {
+		if opt == "bind" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package statfs
+
+import "golang.org/x/sys/unix"
+
+func deviceID(path string) uint64 {
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return 0
+	}
+	return uint64(stat.Dev)
+}
@@ -0,0 +1,7 @@
+//go:build windows
+
+package statfs
+
+func deviceID(path string) uint64 {
+	return 0
+}
@@ -0,0 +1,102 @@
+// Package statfs collects filesystem usage for many partitions concurrently,
+// giving up on mounts that do not answer within a deadline.
+package statfs
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nmollerup/sensu-check-disk/internal/hostroot"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// ErrTimeout is returned for partitions whose usage could not be read before
+// the deadline, typically hung network mounts
+var ErrTimeout = errors.New("timed out")
+
+// Result is the outcome of the usage lookup for a single partition
+type Result struct {
+	Partition disk.PartitionStat
+	Usage     *disk.UsageStat
+	Err       error
+
+	// DeviceID identifies the filesystem (st_dev of the mountpoint), or is
+	// zero when unavailable
+	DeviceID uint64
+
+	// Aliases lists the other mountpoints of the same filesystem when
+	// results have been merged with Dedupe
+	Aliases []string
+}
+
+// usageFunc and deviceIDFunc are replaced in tests
+var (
+	usageFunc    = disk.Usage
+	deviceIDFunc = deviceID
+)
+
+// Collect reads the usage of the partitions with at most workers lookups in
+// flight. Mountpoints are resolved below the host root, if one is set. A
+// lookup taking longer than timeout yields ErrTimeout; the stuck statfs call
+// is abandoned rather than waited for, so one hung mount cannot hold up the
+// others. Results are returned in the order of partitions.
+func Collect(partitions []disk.PartitionStat, workers int, timeout time.Duration) []Result {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]Result, len(partitions))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = lookup(partitions[i], timeout)
+			}
+		}()
+	}
+
+	for i := range partitions {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+func lookup(partition disk.PartitionStat, timeout time.Duration) Result {
+	result := Result{Partition: partition}
+
+	// Buffered so the goroutine can finish and be collected even after we
+	// stopped waiting for it
+	done := make(chan Result, 1)
+	usageOf, deviceIDOf := usageFunc, deviceIDFunc
+	go func() {
+		path := hostroot.Path(partition.Mountpoint)
+		usage, err := usageOf(path)
+		r := Result{Partition: partition, Usage: usage, Err: err}
+		if err == nil {
+			r.DeviceID = deviceIDOf(path)
+		}
+		done <- r
+	}()
+
+	if timeout <= 0 {
+		return <-done
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case result = <-done:
+	case <-timer.C:
+		result.Err = ErrTimeout
+	}
+	return result
+}
@@ -0,0 +1,142 @@
+package statfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nmollerup/sensu-check-disk/internal/btrfs"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+func TestCollect(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+
+	usageFunc = func(path string) (*disk.UsageStat, error) {
+		switch path {
+		case "/mnt/nfs":
+			<-hang
+		case "/mnt/broken":
+			return nil, errors.New("input/output error")
+		}
+		return &disk.UsageStat{Path: path}, nil
+	}
+	defer func() { usageFunc = disk.Usage }()
+
+	partitions := []disk.PartitionStat{
+		{Mountpoint: "/"},
+		{Mountpoint: "/mnt/nfs"},
+		{Mountpoint: "/mnt/broken"},
+		{Mountpoint: "/home"},
+	}
+
+	start := time.Now()
+	results := Collect(partitions, 2, 50*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected hung mount to be abandoned, took %s", elapsed)
+	}
+
+	if len(results) != len(partitions) {
+		t.Fatalf("expected %d results, got %d", len(partitions), len(results))
+	}
+	for i, result := range results {
+		if result.Partition.Mountpoint != partitions[i].Mountpoint {
+			t.Errorf("result %d is for %s, want %s", i, result.Partition.Mountpoint, partitions[i].Mountpoint)
+		}
+	}
+	if results[0].Err != nil || results[0].Usage == nil {
+		t.Errorf("expected usage for /, got %+v", results[0])
+	}
+	if !errors.Is(results[1].Err, ErrTimeout) {
+		t.Errorf("expected timeout for /mnt/nfs, got %v", results[1].Err)
+	}
+	if results[2].Err == nil || errors.Is(results[2].Err, ErrTimeout) {
+		t.Errorf("expected error for /mnt/broken, got %v", results[2].Err)
+	}
+	if results[3].Err != nil {
+		t.Errorf("expected usage for /home, got %v", results[3].Err)
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	results := []Result{
+		{Partition: disk.PartitionStat{Device: "/dev/sda1", Mountpoint: "/var/lib/kubelet/pods/abc/volume-subpaths/x", Opts: []string{"rw", "bind"}}, DeviceID: 2049},
+		{Partition: disk.PartitionStat{Device: "/dev/sda1", Mountpoint: "/"}, DeviceID: 2049},
+		{Partition: disk.PartitionStat{Device: "/dev/sdb1", Mountpoint: "/data"}, DeviceID: 2065},
+		{Partition: disk.PartitionStat{Device: "/dev/sda1", Mountpoint: "/var/lib/docker"}, DeviceID: 2049},
+		{Partition: disk.PartitionStat{Device: "/dev/sdc1", Mountpoint: "/broken"}, Err: errors.New("stale file handle")},
+		{Partition: disk.PartitionStat{Device: "/dev/sdc1", Mountpoint: "/broken2"}, Err: errors.New("stale file handle")},
+	}
+
+	for _, by := range []string{DedupeDevice, DedupeFilesystem} {
+		merged := Dedupe(results, by)
+		if len(merged) != 4 {
+			t.Fatalf("%s: expected 4 results, got %d: %+v", by, len(merged), merged)
+		}
+		root := merged[0]
+		if root.Partition.Mountpoint != "/" {
+			t.Errorf("%s: expected / to be canonical, got %s", by, root.Partition.Mountpoint)
+		}
+		wantAliases := []string{"/var/lib/docker", "/var/lib/kubelet/pods/abc/volume-subpaths/x"}
+		if len(root.Aliases) != 2 || root.Aliases[0] != wantAliases[0] || root.Aliases[1] != wantAliases[1] {
+			t.Errorf("%s: expected aliases %v, got %v", by, wantAliases, root.Aliases)
+		}
+		if merged[1].Partition.Mountpoint != "/data" || len(merged[1].Aliases) != 0 {
+			t.Errorf("%s: expected /data on its own, got %+v", by, merged[1])
+		}
+	}
+
+	if merged := Dedupe(results, DedupeNone); len(merged) != len(results) {
+		t.Errorf("expected no merging with %s, got %d results", DedupeNone, len(merged))
+	}
+}
+
+func TestDedupe_DeviceWithoutNode(t *testing.T) {
+	results := []Result{
+		{Partition: disk.PartitionStat{Device: "tmpfs", Mountpoint: "/run", Fstype: "tmpfs"}, DeviceID: 24},
+		{Partition: disk.PartitionStat{Device: "tmpfs", Mountpoint: "/tmp", Fstype: "tmpfs"}, DeviceID: 25},
+		{Partition: disk.PartitionStat{Device: "server:/export", Mountpoint: "/mnt/a", Fstype: "nfs4"}, DeviceID: 51},
+		{Partition: disk.PartitionStat{Device: "server:/export", Mountpoint: "/mnt/b", Fstype: "nfs4"}, DeviceID: 51},
+	}
+
+	// Unrelated tmpfs mounts stay apart, while the same NFS filesystem
+	// mounted twice is merged by its filesystem ID
+	merged := Dedupe(results, DedupeDevice)
+	if len(merged) != 3 {
+		t.Fatalf("expected 3 results, got %d: %+v", len(merged), merged)
+	}
+	if merged[2].Partition.Mountpoint != "/mnt/a" || len(merged[2].Aliases) != 1 || merged[2].Aliases[0] != "/mnt/b" {
+		t.Errorf("expected /mnt/b to be an alias of /mnt/a, got %+v", merged[2])
+	}
+}
+
+func TestDedupe_BtrfsSubvolumes(t *testing.T) {
+	btrfsUUIDFunc = func(sysfs, device string) (string, error) {
+		if device == "/dev/sda2" || device == "/dev/sdb1" {
+			return "0a1b2c3d-uuid", nil
+		}
+		return "", errors.New("no btrfs filesystem found")
+	}
+	defer func() { btrfsUUIDFunc = btrfs.UUID }()
+
+	// Each subvolume mount has its own anonymous st_dev, and a multi-device
+	// filesystem may be listed under any member
+	results := []Result{
+		{Partition: disk.PartitionStat{Device: "/dev/sda2", Mountpoint: "/", Fstype: "btrfs"}, DeviceID: 38},
+		{Partition: disk.PartitionStat{Device: "/dev/sda2", Mountpoint: "/home", Fstype: "btrfs"}, DeviceID: 39},
+		{Partition: disk.PartitionStat{Device: "/dev/sdb1", Mountpoint: "/var/log", Fstype: "btrfs"}, DeviceID: 40},
+		{Partition: disk.PartitionStat{Device: "/dev/sdc1", Mountpoint: "/srv", Fstype: "btrfs"}, DeviceID: 41},
+	}
+
+	merged := Dedupe(results, DedupeFilesystem)
+	if len(merged) != 2 {
+		t.Fatalf("expected 2 results, got %d: %+v", len(merged), merged)
+	}
+	if merged[0].Partition.Mountpoint != "/" || len(merged[0].Aliases) != 2 {
+		t.Errorf("expected / with the other subvolumes as aliases, got %+v", merged[0])
+	}
+	if merged[1].Partition.Mountpoint != "/srv" {
+		t.Errorf("expected /srv on its own by st_dev, got %+v", merged[1])
+	}
+}
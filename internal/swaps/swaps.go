@@ -0,0 +1,87 @@
+// Package swaps reads the active swap areas from /proc/swaps.
+package swaps
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nmollerup/sensu-check-disk/internal/fstab"
+	"github.com/nmollerup/sensu-check-disk/internal/hostroot"
+)
+
+// Swap is one active swap area. Size and Used are in KiB.
+type Swap struct {
+	// Filename is the device node or swap file, with octal escapes decoded
+	Filename string
+	// Type is partition or file
+	Type     string
+	Size     uint64
+	Used     uint64
+	Priority int
+	// Deleted is set for a swap file that was deleted while active
+	Deleted bool
+}
+
+// Read returns the swap areas of the host
+func Read() ([]Swap, error) {
+	return ReadFile(hostroot.Proc("swaps"))
+}
+
+// ReadFile parses the swaps file at path
+func ReadFile(path string) ([]Swap, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// Parse reads swap areas from r, skipping the header line
+func Parse(r io.Reader) ([]Swap, error) {
+	var swaps []Swap
+	scanner := bufio.NewScanner(r)
+	header := true
+	for scanner.Scan() {
+		if header {
+			header = false
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("invalid swaps line: %s", scanner.Text())
+		}
+
+		// A swap file deleted while active is listed as
+		// /swapfile\040(deleted)
+		filename, deleted := strings.CutSuffix(fstab.Unescape(fields[0]), " (deleted)")
+		swap := Swap{
+			Filename: filename,
+			Type:     fields[1],
+			Deleted:  deleted,
+		}
+		var err error
+		if swap.Size, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid size in swaps line: %s", scanner.Text())
+		}
+		if swap.Used, err = strconv.ParseUint(fields[3], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid used size in swaps line: %s", scanner.Text())
+		}
+		if swap.Priority, err = strconv.Atoi(fields[4]); err != nil {
+			return nil, fmt.Errorf("invalid priority in swaps line: %s", scanner.Text())
+		}
+		swaps = append(swaps, swap)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return swaps, nil
+}
@@ -0,0 +1,47 @@
+package swaps
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	data := `Filename				Type		Size		Used		Priority
+/dev/dm-1                               partition	8388604		1024		-2
+/swap\040file                           file		2097148		0		-3
+/old.swap\040(deleted)                  file		1048572		512		-4
+/dev/zram0                              partition	4194300		0		100
+`
+	swaps, err := Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Swap{
+		{Filename: "/dev/dm-1", Type: "partition", Size: 8388604, Used: 1024, Priority: -2},
+		{Filename: "/swap file", Type: "file", Size: 2097148, Priority: -3},
+		{Filename: "/old.swap", Type: "file", Size: 1048572, Used: 512, Priority: -4, Deleted: true},
+		{Filename: "/dev/zram0", Type: "partition", Size: 4194300, Priority: 100},
+	}
+	if len(swaps) != len(want) {
+		t.Fatalf("Parse() returned %d swaps, want %d: %+v", len(swaps), len(want), swaps)
+	}
+	for i := range want {
+		if swaps[i] != want[i] {
+			t.Errorf("swap %d = %+v, want %+v", i, swaps[i], want[i])
+		}
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	swaps, err := Parse(strings.NewReader("Filename\t\t\t\tType\t\tSize\t\tUsed\t\tPriority\n"))
+	if err != nil || len(swaps) != 0 {
+		t.Errorf("Parse() = %+v, %v, want no swaps", swaps, err)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := Parse(strings.NewReader("header\n/dev/sda2 partition big 0 -2\n")); err == nil {
+		t.Error("expected error for a non-numeric size")
+	}
+}